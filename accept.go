@@ -0,0 +1,147 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var acceptResults = flag.Bool(
+	"datadriven-accept", false,
+	"interactively review the results which don't match the expected results, and rewrite the "+
+		"test files with the accepted results; the rejected mismatches fail the test. For local "+
+		"use only: the answers are read from the terminal.",
+)
+
+// rewriting returns true if the test file at path is to be rewritten, either
+// with all the actual results (-rewrite or DATADRIVEN_REWRITE) or with the
+// accepted results (-datadriven-accept).
+func rewriting(path string) bool {
+	return rewriteFile(path) || *acceptResults
+}
+
+// acceptor reviews the mismatches in -datadriven-accept mode. The prompts are
+// written to and the answers read from the terminal, falling back to standard
+// error and input if there is no terminal: go test does not connect the
+// standard input of test binaries.
+var acceptor struct {
+	mu  sync.Mutex
+	in  *bufio.Reader
+	out io.Writer
+	// all is set once the remaining mismatches have been accepted, and quit
+	// once they have been rejected.
+	all, quit bool
+}
+
+// acceptMismatch shows the difference between the expected and the actual
+// results of a directive, and asks whether the actual results are to be
+// accepted. The answer is one of:
+//
+//	y: accept the actual results
+//	n: keep the expected results
+//	a: accept the actual results of this and all the remaining mismatches
+//	q: keep the expected results of this and all the remaining mismatches
+func acceptMismatch(d *TestData, actual string) bool {
+	acceptor.mu.Lock()
+	defer acceptor.mu.Unlock()
+	if acceptor.all || acceptor.quit {
+		return acceptor.all
+	}
+	if acceptor.in == nil {
+		if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+			acceptor.in, acceptor.out = bufio.NewReader(tty), tty
+		} else {
+			acceptor.in, acceptor.out = bufio.NewReader(os.Stdin), os.Stderr
+		}
+	}
+
+	fmt.Fprintf(acceptor.out, "\n%s: %s\n%s", d.Pos, formatDirectiveLine(d.Cmd, d.CmdArgs),
+		acceptDiff(d.Expected, actual))
+	for {
+		fmt.Fprint(acceptor.out, "Accept this change [y,n,a,q]? ")
+		answer, err := acceptor.in.ReadString('\n')
+		switch strings.TrimSpace(answer) {
+		case "y":
+			return true
+		case "n":
+			return false
+		case "a":
+			acceptor.all = true
+			return true
+		case "q":
+			acceptor.quit = true
+			return false
+		}
+		if err != nil {
+			// Without any more answers, keep the expected results.
+			fmt.Fprintln(acceptor.out)
+			acceptor.quit = true
+			return false
+		}
+	}
+}
+
+// acceptDiff returns the difference between the expected and the actual
+// results, as shown by acceptMismatch.
+func acceptDiff(expected, actual string) string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "expected",
+		ToFile:   "actual",
+		Context:  5,
+	})
+	if err != nil {
+		return fmt.Sprintf("expected:\n%s\nfound:\n%s", expected, actual)
+	}
+	return diff
+}
+
+// rejectedMismatches collects the mismatches rejected in -datadriven-accept
+// mode in a test file, including its subtests. They fail the test once the
+// file has been rewritten, so that the other mismatches can still be reviewed
+// and the accepted ones are not lost.
+type rejectedMismatches struct {
+	mu      sync.Mutex
+	reports []string
+}
+
+// add records the rejected mismatch of the directive at pos.
+func (m *rejectedMismatches) add(pos, expected, actual string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports = append(m.reports, fmt.Sprintf(
+		"%s: kept the expected results, which don't match the actual results:\n%s",
+		pos, acceptDiff(expected, actual)))
+}
+
+// report fails the test with the rejected mismatches.
+func (m *rejectedMismatches) report(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.reports {
+		t.Errorf("%s", r)
+	}
+}
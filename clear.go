@@ -0,0 +1,142 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// ClearResults removes the expected results of the directives in the given
+// test file, as if the file had been rewritten by a test in which the
+// directives produced no output. By default, all the directives are cleared;
+// the options restrict this to some of them. The rest of the file is left
+// exactly as it was.
+func ClearResults(path string, opts ...ClearOption) error {
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if finfo.IsDir() {
+		return fmt.Errorf("%s is a directory, not a file", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := ParseFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.ClearResults(opts...)) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, f); err != nil {
+		return err
+	}
+	return writeTestFile(path, buf.Bytes())
+}
+
+// ClearResults removes the expected results of the directives of a parsed
+// file, like the ClearResults function. It returns the directives which had
+// expected results.
+func (f *File) ClearResults(opts ...ClearOption) []*Directive {
+	var o clearOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.clearNodes(f.Nodes, nil /* subtests */, nil /* cleared */)
+}
+
+// clearNodes clears the matching directives among the given nodes,
+// recursively. subtests are the names of the enclosing subtests.
+func (o *clearOptions) clearNodes(nodes []Node, subtests []string, cleared []*Directive) []*Directive {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Directive:
+			if n.Cmd != "subtest" && n.Expected != "" && o.matches(n, subtests) {
+				n.Expected = ""
+				cleared = append(cleared, n)
+			}
+		case *Subtest:
+			cleared = o.clearNodes(n.Nodes, append(subtests, n.Name), cleared)
+		}
+	}
+	return cleared
+}
+
+// ClearOption is an option for ClearResults.
+type ClearOption func(*clearOptions)
+
+type clearOptions struct {
+	commands         []string
+	fromLine, toLine int
+	subtest          string
+}
+
+// ClearCommands restricts ClearResults to the directives with one of the
+// given commands.
+func ClearCommands(cmds ...string) ClearOption {
+	return func(o *clearOptions) {
+		o.commands = append(o.commands, cmds...)
+	}
+}
+
+// ClearLines restricts ClearResults to the directives which span some of the
+// lines between from and to, inclusive. Lines are numbered from 1; a zero to
+// stands for the end of the file.
+func ClearLines(from, to int) ClearOption {
+	return func(o *clearOptions) {
+		o.fromLine, o.toLine = from, to
+	}
+}
+
+// ClearSubtest restricts ClearResults to the directives inside the subtest
+// with the given name, including its nested subtests. The name of a nested
+// subtest includes the names of its parents, as in "parent/child".
+func ClearSubtest(name string) ClearOption {
+	return func(o *clearOptions) {
+		o.subtest = name
+	}
+}
+
+// matches returns true if the directive, inside the given subtests, is to be
+// cleared.
+func (o *clearOptions) matches(d *Directive, subtests []string) bool {
+	if len(o.commands) > 0 {
+		found := false
+		for _, cmd := range o.commands {
+			found = found || cmd == d.Cmd
+		}
+		if !found {
+			return false
+		}
+	}
+	if span := d.Span(); span.EndLine < o.fromLine || (o.toLine > 0 && span.StartLine > o.toLine) {
+		return false
+	}
+	if o.subtest != "" {
+		found := false
+		for _, name := range subtests {
+			found = found || name == o.subtest
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock is the source of time used by Retry, see TestData.Clock.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for the given duration, unless the context is canceled
+	// first, in which case it returns the error of the context.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is the Clock used by default.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx.Done() == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ManualClock is a Clock whose time only changes when it is advanced. Sleeping
+// advances the clock immediately, so that Retry does not wait for real time to
+// pass, and a system under test which uses the same clock sees the time move
+// forward.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+var _ Clock = &ManualClock{}
+
+// NewManualClock returns a ManualClock set to the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep advances the clock by the given duration, unless the context is
+// already canceled.
+func (c *ManualClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Advance(d)
+	return nil
+}
+
+// Advance advances the clock by the given duration.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// WithClock returns a test handler which sets the Clock of each TestData to
+// the given clock before calling f. In addition, the returned handler
+// implements the following directive, instead of calling f:
+//
+//	advance <duration>
+//
+// which sleeps for the given duration, as parsed by time.ParseDuration, using
+// the clock. For a ManualClock, this advances the clock.
+func WithClock(
+	clock Clock, f func(t *testing.T, d *TestData) string,
+) func(t *testing.T, d *TestData) string {
+	g := WithClockAny(clock, func(t testing.TB, d *TestData) string {
+		return f(t.(*testing.T), d)
+	})
+	return func(t *testing.T, d *TestData) string {
+		return g(t, d)
+	}
+}
+
+// WithClockAny is like WithClock but works over a testing.TB.
+func WithClockAny(
+	clock Clock, f func(t testing.TB, d *TestData) string,
+) func(t testing.TB, d *TestData) string {
+	return func(t testing.TB, d *TestData) string {
+		d.Clock = clock
+		if d.Cmd != "advance" {
+			return f(t, d)
+		}
+		if len(d.CmdArgs) != 1 || len(d.CmdArgs[0].Vals) != 0 {
+			d.Fatalf(t, "usage: advance <duration>")
+		}
+		duration, err := time.ParseDuration(d.CmdArgs[0].Key)
+		if err != nil {
+			d.Fatalf(t, "%v", err)
+		}
+		if err := clock.Sleep(context.Background(), duration); err != nil {
+			d.Fatalf(t, "%v", err)
+		}
+		return ""
+	}
+}
@@ -0,0 +1,628 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// comparison compares the actual results of a directive with its expected
+// results. By default, the results must be identical; other comparisons are
+// selected by an argument of the directive, which is handled by the
+// framework once enabled with ComparisonArgs, like the retry argument with
+// RetryArg.
+type comparison interface {
+	// matches returns true if the actual results match the expected results.
+	matches(actual string) bool
+	// rewrite returns the expected results to write when rewriting the test
+	// file, given the actual results. The returned results should match the
+	// actual results.
+	rewrite(actual string) string
+	// explain returns a description of the differences between the expected
+	// and actual results, or "" to use the default diff.
+	explain(actual string) string
+}
+
+// comparisonArgs maps the arguments which select a comparison to the
+// constructor of the comparison, which is passed the argument and the
+// expected results.
+var comparisonArgs = map[string]func(arg CmdArg, expected string) (comparison, error){
+	"json":      newJSONComparison,
+	"patterns":  newPatternComparison,
+	"tolerance": newToleranceComparison,
+	"unordered": newUnorderedComparison,
+}
+
+// stripComparisonArg removes the argument which selects the comparison from
+// the directive, if it is enabled by the options, and returns the comparison.
+func stripComparisonArg(t testing.TB, d *TestData, o *runOptions) comparison {
+	t.Helper()
+	var cmp comparison
+	var cmpArg string
+	for _, arg := range d.CmdArgs {
+		newComparison, ok := comparisonArgs[arg.Key]
+		if !ok || !o.comparisonArg(arg.Key) {
+			continue
+		}
+		if cmp != nil {
+			d.Fatalf(t, "the %s and %s arguments cannot be used together", cmpArg, arg.Key)
+		}
+		var err error
+		if cmp, err = newComparison(arg, d.Expected); err != nil {
+			d.Fatalf(t, "%s: %v", arg.Key, err)
+		}
+		cmpArg = arg.Key
+	}
+	if cmpArg != "" {
+		stripArg(d, cmpArg)
+	}
+	if cmp == nil {
+		return exactComparison{expected: d.Expected}
+	}
+	return cmp
+}
+
+// stripArg removes the first argument with the given key from the directive,
+// and returns it. The arguments of the parsed directive are not modified.
+func stripArg(d *TestData, key string) (CmdArg, bool) {
+	for i, arg := range d.CmdArgs {
+		if arg.Key == key {
+			d.CmdArgs = append(d.CmdArgs[:i:i], d.CmdArgs[i+1:]...)
+			return arg, true
+		}
+	}
+	return CmdArg{}, false
+}
+
+// exactComparison is the default comparison.
+type exactComparison struct {
+	expected string
+}
+
+func (c exactComparison) matches(actual string) bool   { return actual == c.expected }
+func (c exactComparison) rewrite(actual string) string { return actual }
+func (c exactComparison) explain(actual string) string { return "" }
+
+// patternComparison is selected by the patterns argument. Each line of the
+// expected results is one of:
+//
+//   - "...", which matches any number of lines, including none;
+//   - "re:<regexp>", which matches a line matching the regexp entirely;
+//   - any other line, which matches itself, except that "<any>" within the
+//     line matches any sequence of characters.
+//
+// When rewriting, the pattern lines which match the actual results are kept.
+type patternComparison struct {
+	expected string
+	patterns []linePattern
+}
+
+// linePattern is a line of the expected results of a patternComparison.
+type linePattern struct {
+	line string
+	// re is set for the lines which are patterns.
+	re       *regexp.Regexp
+	ellipsis bool
+}
+
+func (p *linePattern) matches(line string) bool {
+	if p.re != nil {
+		return p.re.MatchString(line)
+	}
+	return p.line == line
+}
+
+func newPatternComparison(arg CmdArg, expected string) (comparison, error) {
+	if len(arg.Vals) > 0 {
+		return nil, fmt.Errorf("unexpected value")
+	}
+	c := &patternComparison{expected: expected}
+	for i, line := range splitLines(expected) {
+		p := linePattern{line: line}
+		switch {
+		case line == "...":
+			p.ellipsis = true
+		case strings.HasPrefix(line, "re:"):
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(line, "re:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("line %d of the expected results: %v", i+1, err)
+			}
+			p.re = re
+		case strings.Contains(line, "<any>"):
+			parts := strings.Split(line, "<any>")
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			p.re = regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+		}
+		c.patterns = append(c.patterns, p)
+	}
+	return c, nil
+}
+
+func (c *patternComparison) matches(actual string) bool {
+	lines := splitLines(actual)
+	// matched[i][j] is set if patterns[i:] match lines[j:].
+	matched := make([][]bool, len(c.patterns)+1)
+	for i := range matched {
+		matched[i] = make([]bool, len(lines)+1)
+	}
+	matched[len(c.patterns)][len(lines)] = true
+	for i := len(c.patterns) - 1; i >= 0; i-- {
+		p := &c.patterns[i]
+		for j := len(lines); j >= 0; j-- {
+			if p.ellipsis {
+				matched[i][j] = matched[i+1][j] || (j < len(lines) && matched[i][j+1])
+			} else {
+				matched[i][j] = j < len(lines) && p.matches(lines[j]) && matched[i+1][j+1]
+			}
+		}
+	}
+	return matched[0][0]
+}
+
+// rewrite aligns the patterns (other than the ellipses) with the actual lines,
+// maximizing the number of lines which match. The pattern lines which match
+// are kept; between them, the lines which don't match are replaced with the
+// actual lines, except that an ellipsis in such a gap keeps standing for the
+// actual lines in the middle of the gap.
+func (c *patternComparison) rewrite(actual string) string {
+	if c.matches(actual) {
+		return c.expected
+	}
+	lines := splitLines(actual)
+	// lcs[i][j] is the maximum number of matching lines between patterns[i:]
+	// and lines[j:], not counting the ellipses.
+	lcs := make([][]int, len(c.patterns)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lines)+1)
+	}
+	for i := len(c.patterns) - 1; i >= 0; i-- {
+		for j := len(lines) - 1; j >= 0; j-- {
+			lcs[i][j] = lcs[i+1][j]
+			if lcs[i][j+1] > lcs[i][j] {
+				lcs[i][j] = lcs[i][j+1]
+			}
+			if p := &c.patterns[i]; !p.ellipsis && p.matches(lines[j]) && lcs[i+1][j+1]+1 > lcs[i][j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			}
+		}
+	}
+
+	var buf strings.Builder
+	// gap writes the lines for the patterns and the actual lines between two
+	// matching lines.
+	gap := func(patterns []linePattern, lines []string) {
+		first, last := -1, -1
+		for i := range patterns {
+			if patterns[i].ellipsis {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			for _, l := range lines {
+				buf.WriteString(l + "\n")
+			}
+			return
+		}
+		// Replace the patterns before and after the ellipses with as many
+		// lines, and let the ellipsis stand for the rest.
+		before := first
+		if before > len(lines) {
+			before = len(lines)
+		}
+		after := len(patterns) - last - 1
+		if after > len(lines)-before {
+			after = len(lines) - before
+		}
+		for _, l := range lines[:before] {
+			buf.WriteString(l + "\n")
+		}
+		buf.WriteString("...\n")
+		for _, l := range lines[len(lines)-after:] {
+			buf.WriteString(l + "\n")
+		}
+	}
+	i, j := 0, 0
+	gapI, gapJ := 0, 0
+	for i < len(c.patterns) && j < len(lines) {
+		p := &c.patterns[i]
+		switch {
+		case !p.ellipsis && p.matches(lines[j]) && lcs[i][j] == lcs[i+1][j+1]+1:
+			gap(c.patterns[gapI:i], lines[gapJ:j])
+			buf.WriteString(p.line + "\n")
+			i, j = i+1, j+1
+			gapI, gapJ = i, j
+		case lcs[i][j] == lcs[i+1][j]:
+			i++
+		default:
+			j++
+		}
+	}
+	gap(c.patterns[gapI:], lines[gapJ:])
+	return buf.String()
+}
+
+func (c *patternComparison) explain(actual string) string { return "" }
+
+// unorderedComparison is selected by the unordered argument. The expected
+// and actual results must contain the same lines, in any order. With
+// unordered=blocks, they must contain the same blocks of lines, separated by
+// blank lines, in any order. When rewriting, the lines (or blocks) are
+// sorted.
+type unorderedComparison struct {
+	blocks   bool
+	expected []string
+}
+
+func newUnorderedComparison(arg CmdArg, expected string) (comparison, error) {
+	c := &unorderedComparison{}
+	switch {
+	case len(arg.Vals) == 0 || (len(arg.Vals) == 1 && arg.Vals[0] == "lines"):
+	case len(arg.Vals) == 1 && arg.Vals[0] == "blocks":
+		c.blocks = true
+	default:
+		return nil, fmt.Errorf("expected unordered, unordered=lines or unordered=blocks")
+	}
+	c.expected = c.split(expected)
+	return c, nil
+}
+
+// split returns the sorted lines or blocks of the results.
+func (c *unorderedComparison) split(s string) []string {
+	var res []string
+	if !c.blocks {
+		res = splitLines(s)
+	} else {
+		var block []string
+		for _, line := range append(splitLines(s), "") {
+			if strings.TrimSpace(line) != "" {
+				block = append(block, line)
+			} else if len(block) > 0 {
+				res = append(res, strings.Join(block, "\n"))
+				block = nil
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (c *unorderedComparison) matches(actual string) bool {
+	a := c.split(actual)
+	if len(a) != len(c.expected) {
+		return false
+	}
+	for i := range a {
+		if a[i] != c.expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *unorderedComparison) rewrite(actual string) string {
+	sep := "\n"
+	if c.blocks {
+		sep = "\n\n"
+	}
+	a := c.split(actual)
+	if len(a) == 0 {
+		return ""
+	}
+	return strings.Join(a, sep) + "\n"
+}
+
+// explain lists the missing and unexpected lines (or blocks).
+func (c *unorderedComparison) explain(actual string) string {
+	// Both slices are sorted, so they can be merged.
+	a := c.split(actual)
+	var missing, unexpected []string
+	i, j := 0, 0
+	for i < len(c.expected) || j < len(a) {
+		switch {
+		case j == len(a) || (i < len(c.expected) && c.expected[i] < a[j]):
+			missing = append(missing, c.expected[i])
+			i++
+		case i == len(c.expected) || a[j] < c.expected[i]:
+			unexpected = append(unexpected, a[j])
+			j++
+		default:
+			i, j = i+1, j+1
+		}
+	}
+	var buf strings.Builder
+	what := "lines"
+	if c.blocks {
+		what = "blocks"
+	}
+	for _, l := range []struct {
+		title string
+		items []string
+	}{{"missing", missing}, {"unexpected", unexpected}} {
+		if len(l.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %s:\n", l.title, what)
+		for _, item := range l.items {
+			fmt.Fprintf(&buf, "  %s\n", strings.ReplaceAll(item, "\n", "\n  "))
+			if c.blocks {
+				buf.WriteString("\n")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// jsonComparison is selected by the json argument. The expected and actual
+// results must be equivalent JSON values, regardless of the order of the
+// object keys and of the formatting. When rewriting, the actual value is
+// written in canonical form, indented, with sorted keys.
+type jsonComparison struct {
+	expected interface{}
+	// err is the error parsing the expected results.
+	err error
+}
+
+func newJSONComparison(arg CmdArg, expected string) (comparison, error) {
+	if len(arg.Vals) > 0 {
+		return nil, fmt.Errorf("unexpected value")
+	}
+	c := &jsonComparison{}
+	c.expected, c.err = parseJSON(expected)
+	return c, nil
+}
+
+// parseJSON parses a single JSON value, keeping the numbers as written.
+func parseJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func (c *jsonComparison) matches(actual string) bool {
+	if c.err != nil {
+		return false
+	}
+	v, err := parseJSON(actual)
+	return err == nil && len(jsonDiff("$", c.expected, v, nil)) == 0
+}
+
+func (c *jsonComparison) rewrite(actual string) string {
+	v, err := parseJSON(actual)
+	if err != nil {
+		return actual
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return actual
+	}
+	return buf.String()
+}
+
+// explain lists the differences by JSON path, for example:
+//
+//	$.a[1]: expected 2, found 3
+//	$.b: missing
+func (c *jsonComparison) explain(actual string) string {
+	if c.err != nil {
+		return fmt.Sprintf("the expected results are not valid JSON: %v\n", c.err)
+	}
+	v, err := parseJSON(actual)
+	if err != nil {
+		return fmt.Sprintf("the actual results are not valid JSON: %v\n%s", err, actual)
+	}
+	return strings.Join(jsonDiff("$", c.expected, v, nil), "\n") + "\n"
+}
+
+// jsonDiff appends the differences between two JSON values to diffs.
+func jsonDiff(path string, expected, actual interface{}, diffs []string) []string {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(e)+len(a))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		for k := range a {
+			if _, ok := e[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + jsonPathKey(k)
+			ev, eok := e[k]
+			av, aok := a[k]
+			switch {
+			case !aok:
+				diffs = append(diffs, p+": missing")
+			case !eok:
+				diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", p, jsonString(av)))
+			default:
+				diffs = jsonDiff(p, ev, av, diffs)
+			}
+		}
+		return diffs
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(e) || i < len(a); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(a):
+				diffs = append(diffs, p+": missing")
+			case i >= len(e):
+				diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", p, jsonString(a[i])))
+			default:
+				diffs = jsonDiff(p, e[i], a[i], diffs)
+			}
+		}
+		return diffs
+	case json.Number:
+		if a, ok := actual.(json.Number); ok {
+			if e == a {
+				return diffs
+			}
+			// Compare the values, so that 1.0 is equal to 1.
+			ef, eerr := strconv.ParseFloat(string(e), 64)
+			af, aerr := strconv.ParseFloat(string(a), 64)
+			if eerr == nil && aerr == nil && ef == af {
+				return diffs
+			}
+		}
+	default:
+		// A string, a boolean, or null.
+		if expected == actual {
+			return diffs
+		}
+	}
+	return append(diffs, fmt.Sprintf("%s: expected %s, found %s", path, jsonString(expected), jsonString(actual)))
+}
+
+// jsonPathKey returns the element of a JSON path for an object key.
+func jsonPathKey(k string) string {
+	if jsonIdentRe.MatchString(k) {
+		return "." + k
+	}
+	return "[" + strconv.Quote(k) + "]"
+}
+
+var jsonIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonString returns the compact JSON form of a value.
+func jsonString(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// toleranceComparison is selected by the tolerance argument, as in
+// tolerance=1e-9. The numbers in the expected and actual results are compared
+// within the tolerance, relative to the magnitude of the numbers (or
+// absolute, for numbers smaller than 1), and the rest of the results exactly.
+// When rewriting, the expected lines which match are kept.
+type toleranceComparison struct {
+	tolerance float64
+	expected  string
+}
+
+func newToleranceComparison(arg CmdArg, expected string) (comparison, error) {
+	if len(arg.Vals) != 1 {
+		return nil, fmt.Errorf("expected tolerance=<number>")
+	}
+	tolerance, err := strconv.ParseFloat(arg.Vals[0], 64)
+	if err != nil || tolerance < 0 {
+		return nil, fmt.Errorf("invalid tolerance %q", arg.Vals[0])
+	}
+	return &toleranceComparison{tolerance: tolerance, expected: expected}, nil
+}
+
+var numberRe = regexp.MustCompile(`[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`)
+
+// within returns true if the actual text matches the expected text.
+func (c *toleranceComparison) within(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	e := numberRe.FindAllStringIndex(expected, -1)
+	a := numberRe.FindAllStringIndex(actual, -1)
+	if len(e) != len(a) {
+		return false
+	}
+	// ePos and aPos are the ends of the previous numbers.
+	ePos, aPos := 0, 0
+	for i := range e {
+		if expected[ePos:e[i][0]] != actual[aPos:a[i][0]] {
+			return false
+		}
+		ef, eerr := strconv.ParseFloat(expected[e[i][0]:e[i][1]], 64)
+		af, aerr := strconv.ParseFloat(actual[a[i][0]:a[i][1]], 64)
+		if eerr != nil || aerr != nil {
+			// Out of range.
+			if expected[e[i][0]:e[i][1]] != actual[a[i][0]:a[i][1]] {
+				return false
+			}
+		} else if math.Abs(ef-af) > c.tolerance*math.Max(1, math.Max(math.Abs(ef), math.Abs(af))) {
+			return false
+		}
+		ePos, aPos = e[i][1], a[i][1]
+	}
+	return expected[ePos:] == actual[aPos:]
+}
+
+func (c *toleranceComparison) matches(actual string) bool {
+	return c.within(c.expected, actual)
+}
+
+func (c *toleranceComparison) rewrite(actual string) string {
+	if c.matches(actual) {
+		return c.expected
+	}
+	expectedLines, actualLines := splitLines(c.expected), splitLines(actual)
+	if len(expectedLines) != len(actualLines) {
+		return actual
+	}
+	var buf strings.Builder
+	for i := range actualLines {
+		if c.within(expectedLines[i], actualLines[i]) {
+			buf.WriteString(expectedLines[i] + "\n")
+		} else {
+			buf.WriteString(actualLines[i] + "\n")
+		}
+	}
+	return buf.String()
+}
+
+func (c *toleranceComparison) explain(actual string) string { return "" }
+
+// splitLines splits results into lines, without their terminators.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
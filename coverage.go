@@ -0,0 +1,126 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var coverageFile = flag.String(
+	"datadriven-coverage", "",
+	"append a record of the commands and arguments used by the test files, and of the arguments "+
+		"read by the tests, to the given file.",
+)
+
+// As for DATADRIVEN_QUIET_LOG, the environment variable allows enabling
+// coverage for all the packages of a go test run, including those which don't
+// use datadriven.
+func init() {
+	const coverageEnvVar = "DATADRIVEN_COVERAGE"
+	if str, ok := os.LookupEnv(coverageEnvVar); ok {
+		*coverageFile = str
+	}
+}
+
+// Coverage records are lines of tab-separated fields, appended to the file
+// given by -datadriven-coverage or DATADRIVEN_COVERAGE:
+//
+//	cmd <command>
+//	arg <command> <key>
+//	read <command> <key>
+//
+// A cmd record is written for each command used in a test file, an arg record
+// for each argument used with a command, and a read record for each argument
+// that a test looked up through TestData.Arg, HasArg, ScanArgs or
+// MaybeScanArgs while running a command, whether or not the argument was
+// present. Each record is written at most once by a test binary, but several
+// test binaries can append to the same file, so the file can contain
+// duplicates.
+const (
+	CoverageCmd  = "cmd"
+	CoverageArg  = "arg"
+	CoverageRead = "read"
+)
+
+// coverage collects the coverage records of a test binary.
+var coverage struct {
+	mu sync.Mutex
+	// file is the coverage file of the collected records; the records are
+	// collected again if the coverage file changes.
+	file string
+	// seen contains the records which were already collected.
+	seen map[string]bool
+	// pending contains the records which were not written yet.
+	pending []string
+}
+
+// recordCoverage collects a coverage record, if coverage is enabled.
+func recordCoverage(fields ...string) {
+	if *coverageFile == "" {
+		return
+	}
+	record := strings.Join(fields, "\t")
+	coverage.mu.Lock()
+	defer coverage.mu.Unlock()
+	if coverage.file != *coverageFile {
+		coverage.file, coverage.seen, coverage.pending = *coverageFile, nil, nil
+	}
+	if coverage.seen[record] {
+		return
+	}
+	if coverage.seen == nil {
+		coverage.seen = make(map[string]bool)
+	}
+	coverage.seen[record] = true
+	coverage.pending = append(coverage.pending, record)
+}
+
+// recordDirectiveCoverage collects the coverage records for the command and
+// the arguments of a directive.
+func recordDirectiveCoverage(d *TestData) {
+	recordCoverage(CoverageCmd, d.Cmd)
+	for _, arg := range d.CmdArgs {
+		recordCoverage(CoverageArg, d.Cmd, arg.Key)
+	}
+}
+
+// flushCoverage appends the pending coverage records to the coverage file. It
+// is called once each test file has run.
+func flushCoverage() error {
+	coverage.mu.Lock()
+	defer coverage.mu.Unlock()
+	if len(coverage.pending) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(coverage.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// Write all the records at once, so that the records of concurrent test
+	// binaries are not interleaved.
+	_, err = f.WriteString(strings.Join(coverage.pending, "\n") + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing coverage: %w", err)
+	}
+	coverage.pending = nil
+	return nil
+}
@@ -12,26 +12,133 @@
 // implied. See the License for the specific language governing
 // permissions and limitations under the License.
 
+// datadriven-clear removes the expected results of the directives in test
+// files; see datadriven.ClearResults. Directories are walked recursively,
+// visiting the same files as datadriven.Walk.
+//
+// With -n, the directives which would be cleared are listed, and the files are
+// left unchanged. With -check, they are listed as well, and the exit status is
+// 1 if there are any.
+//
+// The -commands, -lines and -subtest flags restrict the directives which are
+// cleared; the rest of the files are left exactly as they were.
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/cockroachdb/datadriven"
 )
 
+var (
+	dryRun   = flag.Bool("n", false, "list the directives which would be cleared, without changing the files")
+	check    = flag.Bool("check", false, "like -n, but exit with status 1 if any directive has expected results")
+	commands = flag.String("commands", "", "comma-separated list of the commands whose results are cleared (default all)")
+	lines    = flag.String("lines", "", "only clear the directives spanning the given `line` or range of lines, such as 10-20 or 10-")
+	subtest  = flag.String("subtest", "", "only clear the directives inside the subtest with the given `name`")
+)
+
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <test-file>...", os.Args[0])
-		os.Exit(1)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <test-file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
-	for _, path := range os.Args[1:] {
-		err := datadriven.ClearResults(path)
+	var opts []datadriven.ClearOption
+	if *commands != "" {
+		opts = append(opts, datadriven.ClearCommands(strings.Split(*commands, ",")...))
+	}
+	if *lines != "" {
+		from, to, err := parseLines(*lines)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "invalid -lines: %v\n", err)
+			os.Exit(2)
+		}
+		opts = append(opts, datadriven.ClearLines(from, to))
+	}
+	if *subtest != "" {
+		opts = append(opts, datadriven.ClearSubtest(*subtest))
+	}
+
+	found, failed := false, false
+	for _, path := range flag.Args() {
+		err := datadriven.WalkFiles(path, func(path string) error {
+			// Report the files that can't be cleared, and keep going.
+			cleared, err := clearFile(path, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failed = true
+			}
+			found = found || cleared
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+	if found && *check {
+		os.Exit(1)
+	}
+}
+
+// parseLines parses a line number or a range of lines, such as 10-20, or 10-
+// for the lines up to the end of the file.
+func parseLines(s string) (from, to int, err error) {
+	fromStr, toStr := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		fromStr, toStr = s[:i], s[i+1:]
+	}
+	if from, err = strconv.Atoi(fromStr); err != nil {
+		return 0, 0, err
+	}
+	if toStr != "" {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			return 0, 0, err
 		}
-		fmt.Printf("Cleared %s.\n", path)
 	}
+	return from, to, nil
+}
+
+// clearFile clears the results in a file, and returns true if there were any.
+func clearFile(path string, opts []datadriven.ClearOption) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	f, err := datadriven.ParseFile(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	cleared := f.ClearResults(opts...)
+	if len(cleared) == 0 {
+		return false, nil
+	}
+	if *dryRun || *check {
+		for _, d := range cleared {
+			fmt.Printf("%s:%d: %s\n", path, d.Span().StartLine, d.Cmd)
+		}
+		return true, nil
+	}
+	var buf bytes.Buffer
+	if err := datadriven.Format(&buf, f); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return false, err
+	}
+	fmt.Printf("Cleared %s.\n", path)
+	return true, nil
 }
@@ -0,0 +1,212 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// datadriven-coverage compares the coverage records of a test run with a
+// registry of the commands and arguments supported by the tests. To collect
+// the coverage records, run the tests with DATADRIVEN_COVERAGE set:
+//
+//	rm -f /tmp/coverage
+//	DATADRIVEN_COVERAGE=/tmp/coverage go test ./...
+//	datadriven-coverage -registry commands.txt /tmp/coverage
+//
+// Each line of the registry declares a command, followed by its arguments:
+//
+//	# Comments and blank lines are ignored.
+//	scan reverse limit
+//	put
+//
+// The following problems are reported, and the exit status is 1 if there are
+// any:
+//   - commands and arguments of the registry which no test file uses;
+//   - commands and arguments used in test files which the registry doesn't
+//     declare;
+//   - arguments used in test files which the tests never read.
+//
+// Without -registry, only the last kind of problem is reported.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/datadriven"
+)
+
+var registryFile = flag.String("registry", "", "the `file` declaring the supported commands and arguments")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-registry file] <coverage-file>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	used, read := newCommands(), newCommands()
+	for _, path := range flag.Args() {
+		if err := readCoverage(path, used, read); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	var problems []string
+	if *registryFile != "" {
+		registry, err := readRegistry(*registryFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+		problems = append(problems, diff(registry, used, "not used by any test file")...)
+		problems = append(problems, diff(used, registry, "not declared in the registry")...)
+	}
+	for _, cmd := range used.sorted() {
+		for _, key := range used.args[cmd].sorted() {
+			if !read.has(cmd, key) {
+				problems = append(problems, fmt.Sprintf("argument %s of command %s: never read by the tests", key, cmd))
+			}
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// commands is a set of commands, along with their arguments.
+type commands struct {
+	args map[string]stringSet
+}
+
+type stringSet map[string]struct{}
+
+func (s stringSet) sorted() []string {
+	res := make([]string, 0, len(s))
+	for k := range s {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func newCommands() *commands {
+	return &commands{args: make(map[string]stringSet)}
+}
+
+func (c *commands) addCmd(cmd string) {
+	if _, ok := c.args[cmd]; !ok {
+		c.args[cmd] = make(stringSet)
+	}
+}
+
+func (c *commands) addArg(cmd, key string) {
+	c.addCmd(cmd)
+	c.args[cmd][key] = struct{}{}
+}
+
+func (c *commands) has(cmd, key string) bool {
+	_, ok := c.args[cmd][key]
+	return ok
+}
+
+func (c *commands) sorted() []string {
+	res := make([]string, 0, len(c.args))
+	for cmd := range c.args {
+		res = append(res, cmd)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// diff returns a problem for each command and argument of a which is not in b.
+func diff(a, b *commands, problem string) []string {
+	var res []string
+	for _, cmd := range a.sorted() {
+		if _, ok := b.args[cmd]; !ok {
+			res = append(res, fmt.Sprintf("command %s: %s", cmd, problem))
+			continue
+		}
+		for _, key := range a.args[cmd].sorted() {
+			if !b.has(cmd, key) {
+				res = append(res, fmt.Sprintf("argument %s of command %s: %s", key, cmd, problem))
+			}
+		}
+	}
+	return res
+}
+
+// readCoverage reads a coverage file written by datadriven; the commands and
+// arguments used by test files are added to used, and the arguments read by
+// the tests to read.
+func readCoverage(path string, used, read *commands) error {
+	return readLines(path, func(line string) error {
+		fields := strings.Split(line, "\t")
+		switch {
+		case fields[0] == datadriven.CoverageCmd && len(fields) == 2:
+			used.addCmd(fields[1])
+		case fields[0] == datadriven.CoverageArg && len(fields) == 3:
+			used.addArg(fields[1], fields[2])
+		case fields[0] == datadriven.CoverageRead && len(fields) == 3:
+			read.addArg(fields[1], fields[2])
+		default:
+			return fmt.Errorf("invalid coverage record %q", line)
+		}
+		return nil
+	})
+}
+
+// readRegistry reads the declared commands and arguments.
+func readRegistry(path string) (*commands, error) {
+	registry := newCommands()
+	err := readLines(path, func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil
+		}
+		fields := strings.Fields(line)
+		registry.addCmd(fields[0])
+		for _, key := range fields[1:] {
+			registry.addArg(fields[0], key)
+		}
+		return nil
+	})
+	return registry, err
+}
+
+// readLines calls fn for each non-blank line of a file, without surrounding
+// whitespace.
+func readLines(path string, fn func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// datadriven-fmt rewrites test files in canonical form; see
+// datadriven.Canonicalize. Directories are walked recursively, visiting the
+// same files as datadriven.Walk.
+//
+// With -l or -d, the files are not rewritten; instead, the files whose
+// formatting differs are listed, or their diffs are shown, and the exit
+// status is 1 if there are any such files. The exit status is 2 if some of the
+// files could not be formatted, for example because of syntax errors.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cockroachdb/datadriven"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var (
+	list = flag.Bool("l", false, "list files whose formatting differs, instead of rewriting them")
+	diff = flag.Bool("d", false, "display diffs, instead of rewriting files")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-l] [-d] <test-file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	differs, failed := false, false
+	for _, path := range flag.Args() {
+		err := datadriven.WalkFiles(path, func(path string) error {
+			// Report the files that can't be formatted, and keep going.
+			changed, err := formatFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failed = true
+			}
+			differs = differs || changed
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+	if differs && (*list || *diff) {
+		os.Exit(1)
+	}
+}
+
+// formatFile canonicalizes a file, and returns true if its formatting
+// differed.
+func formatFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	f, err := datadriven.ParseFile(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	datadriven.Canonicalize(f)
+	var buf bytes.Buffer
+	if err := datadriven.Format(&buf, f); err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, buf.Bytes()) {
+		return false, nil
+	}
+	if *list {
+		fmt.Println(path)
+	}
+	if *diff {
+		d, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(data)),
+			B:        difflib.SplitLines(buf.String()),
+			FromFile: path + ".orig",
+			ToFile:   path,
+			Context:  3,
+		})
+		if err != nil {
+			return false, err
+		}
+		fmt.Print(d)
+	}
+	if !*list && !*diff {
+		return true, os.WriteFile(path, buf.Bytes(), 0644)
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// datadriven-lint checks test files for problems which would otherwise only be
+// found when running the tests: the syntax errors reported by
+// datadriven.ParseFile, such as unbalanced subtests or unparseable directive
+// lines, as well as directives lacking the ---- separator, whose input extends
+// to the end of the file. Directories are walked recursively, visiting the
+// same files as datadriven.Walk.
+//
+// Each problem is printed as file:line: message, and the exit status is 1 if
+// any problem was found.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cockroachdb/datadriven"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s <test-file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		err := datadriven.WalkFiles(path, func(path string) error {
+			problems, err := lintFile(path)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				fmt.Printf("%s:%d: %s\n", path, p.Line, p.Msg)
+			}
+			failed = failed || len(problems) > 0
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lintFile returns the problems found in a test file, see
+// datadriven.LintFile.
+func lintFile(path string) (datadriven.ParseErrors, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	problems, err := datadriven.LintFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return problems, nil
+}
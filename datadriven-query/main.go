@@ -0,0 +1,162 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// datadriven-query finds the directives in test files which match the given
+// command, argument key or argument value pattern. Directive lines are parsed
+// with datadriven.ParseLine, so that line continuations and lists of values
+// are handled correctly. Directories are walked recursively, visiting the same
+// files as datadriven.Walk.
+//
+// Each matching directive is printed as file:line: directive, with the
+// directive line in canonical form. For example, to find the scan directives
+// with the reverse argument:
+//
+//	datadriven-query -cmd scan -key reverse testdata
+//
+// The exit status is 1 if no directive matched, as for grep.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/datadriven"
+)
+
+var (
+	cmds = flag.String("cmd", "", "comma-separated list of `commands` to match")
+	key  = flag.String("key", "", "match directives with an argument with the given `key`")
+	val  = flag.String("val", "", "match directives with an argument value matching the given `regexp`; "+
+		"with -key, only the values of that argument are considered")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <test-file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var q query
+	if *cmds != "" {
+		q.cmds = strings.Split(*cmds, ",")
+	}
+	q.key = *key
+	if *val != "" {
+		re, err := regexp.Compile(*val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -val: %v\n", err)
+			os.Exit(2)
+		}
+		q.val = re
+	}
+
+	found, failed := false, false
+	for _, path := range flag.Args() {
+		err := datadriven.WalkFiles(path, func(path string) error {
+			matched, err := queryFile(path, &q)
+			if err != nil {
+				// Report the errors, and keep going.
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failed = true
+			}
+			found = found || matched
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
+
+// query is the set of conditions that a directive must satisfy.
+type query struct {
+	cmds []string
+	key  string
+	val  *regexp.Regexp
+}
+
+func (q *query) matches(d *datadriven.Directive) bool {
+	if len(q.cmds) > 0 {
+		found := false
+		for _, cmd := range q.cmds {
+			found = found || cmd == d.Cmd
+		}
+		if !found {
+			return false
+		}
+	} else if d.Cmd == "subtest" {
+		// Subtest markers are only matched explicitly.
+		return false
+	}
+	if q.key == "" && q.val == nil {
+		return true
+	}
+	for _, arg := range d.CmdArgs {
+		if q.key != "" && arg.Key != q.key {
+			continue
+		}
+		if q.val == nil {
+			return true
+		}
+		for _, v := range arg.Vals {
+			if q.val.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryFile prints the matching directives of a file, and returns true if
+// there were any. The directives of a file with syntax errors are still
+// queried, but the errors are returned.
+func queryFile(path string, q *query) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	f, err := datadriven.ParseFile(bytes.NewReader(data))
+	var perrs datadriven.ParseErrors
+	if err != nil && !errors.As(err, &perrs) {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	found := false
+	f.Inspect(func(n datadriven.Node) bool {
+		if d, ok := n.(*datadriven.Directive); ok && d.Cmd != "" && q.matches(d) {
+			fmt.Printf("%s:%d: %s\n", path, d.Span().StartLine, d.Line())
+			found = true
+		}
+		return true
+	})
+	if err != nil {
+		return found, fmt.Errorf("%s: %w", path, err)
+	}
+	return found, nil
+}
@@ -15,16 +15,17 @@
 package datadriven
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -65,6 +66,76 @@ func init() {
 	}
 }
 
+// rewriteFilter, if set, selects the test files to rewrite, as specified by
+// the DATADRIVEN_REWRITE environment variable.
+var rewriteFilter func(path string) bool
+
+// As for DATADRIVEN_QUIET_LOG, the environment variable allows rewriting the
+// test files across all packages, including those which don't use datadriven.
+// It can also restrict the rewrite to some files:
+//
+//	DATADRIVEN_REWRITE=1                    rewrite all the files, as -rewrite
+//	DATADRIVEN_REWRITE='testdata/logprops/*' rewrite the files matching a glob
+//	DATADRIVEN_REWRITE='re:sql/.*/join'      rewrite the files matching a regexp
+//
+// A glob (see path.Match) must match the path of the file as passed to
+// RunTest, its absolute path, or its name. A regexp must match a part of the
+// absolute path of the file. The paths use forward slashes. The other files
+// are run normally.
+func init() {
+	const rewriteEnvVar = "DATADRIVEN_REWRITE"
+	str, ok := os.LookupEnv(rewriteEnvVar)
+	if !ok || str == "" {
+		return
+	}
+	filter, err := parseRewriteFilter(str)
+	if err != nil {
+		panic(fmt.Sprintf("error parsing %s: %s", rewriteEnvVar, err))
+	}
+	rewriteFilter = filter
+}
+
+// parseRewriteFilter parses the value of DATADRIVEN_REWRITE.
+func parseRewriteFilter(str string) (func(path string) bool, error) {
+	if v, err := strconv.ParseBool(str); err == nil {
+		return func(string) bool { return v }, nil
+	}
+	if strings.HasPrefix(str, "re:") {
+		re, err := regexp.Compile(strings.TrimPrefix(str, "re:"))
+		if err != nil {
+			return nil, err
+		}
+		return func(p string) bool {
+			return re.MatchString(absSlashPath(p))
+		}, nil
+	}
+	if _, err := path.Match(str, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", str, err)
+	}
+	return func(p string) bool {
+		for _, name := range []string{filepath.ToSlash(p), absSlashPath(p), path.Base(filepath.ToSlash(p))} {
+			if ok, _ := path.Match(str, name); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// absSlashPath returns the absolute path of a file, with forward slashes.
+func absSlashPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	return filepath.ToSlash(p)
+}
+
+// rewriteFile returns true if the test file at path is to be rewritten with
+// the actual results, because of -rewrite or DATADRIVEN_REWRITE.
+func rewriteFile(path string) bool {
+	return *rewriteTestFiles || (rewriteFilter != nil && rewriteFilter(path))
+}
+
 // RunTest invokes a data-driven test. The test cases are contained in a
 // separate test file and are dynamically loaded, parsed, and executed by this
 // testing framework. By convention, test files are typically located in a
@@ -97,6 +168,7 @@ func init() {
 // The function must returns the actual results of the case, which
 // RunTest() compares with the expected results. If the two are not
 // equal, the test is marked to fail.
+// Alternatively, the function can write the actual results to TestData.Out.
 //
 // Note that RunTest() creates a sub-instance of testing.T for each
 // directive in the input file. It is thus unsafe/invalid to call
@@ -110,8 +182,41 @@ func init() {
 //   - place the expected error details in the expected results
 //     in the input file.
 //
+// RunTestErr does this in a standard form for functions which return an
+// error.
+//
 // It is also possible for a test to report an _unexpected_ test
 // error by calling t.Error().
+//
+// The following argument is only handled by the framework, for any command,
+// once enabled with the RetryArg option of RunTestWithOptions; otherwise it is
+// passed to the function like any other argument:
+//   - retry (or retry=<duration>): the function is called repeatedly until its
+//     results match the expected results, as with TestData.Retry (or
+//     TestData.RetryFor), using the Clock set by the function, if any (see
+//     WithClock).
+//
+// The following arguments, which select how the actual results are compared
+// with the expected results, are only handled by the framework once enabled
+// with the ComparisonArgs option of RunTestWithOptions (or WalkWithOptions);
+// otherwise they are passed to the function like any other argument. At most
+// one of them can be used in a directive.
+//   - patterns: the expected results can contain patterns: a line starting
+//     with "re:" is a regexp which must match an entire line, "<any>" matches
+//     any text within a line, and a line containing only "..." matches any
+//     number of lines. When rewriting, the patterns which still match are
+//     kept.
+//   - unordered (or unordered=blocks): the expected and actual results must
+//     contain the same lines (or blocks of lines separated by blank lines), in
+//     any order. When rewriting, the lines (or blocks) are sorted.
+//   - json: the expected and actual results must be equivalent JSON values,
+//     regardless of formatting and of the order of the object keys; the
+//     differences are reported by JSON path. When rewriting, the actual value
+//     is written indented, with sorted keys.
+//   - tolerance=<number>: the numbers in the expected and actual results must
+//     be equal within the given tolerance, relative to their magnitude (or
+//     absolute, for numbers smaller than 1), and the rest of the results must
+//     be identical. When rewriting, the expected lines which match are kept.
 func RunTest(t *testing.T, path string, f func(t *testing.T, d *TestData) string) {
 	t.Helper()
 
@@ -122,8 +227,63 @@ func RunTest(t *testing.T, path string, f func(t *testing.T, d *TestData) string
 
 // RunTestAny is like RunTest but works over a testing.TB.
 func RunTestAny(t testing.TB, path string, f func(t testing.TB, d *TestData) string) {
+	t.Helper()
+	runTestFile(t, path, f, nil /* newHandler */, nil /* opts */)
+}
+
+// RunTestParallel is like RunTest, but allows the subtests of the file which
+// are declared as parallel to run concurrently:
+//
+//	subtest <name> parallel
+//	<directives>...
+//	subtest end
+//
+// Each such subtest is run in a parallel Go subtest (see testing.T.Parallel)
+// with its own state: newHandler is called once for the file, to obtain the
+// handler for the directives outside parallel subtests, and then once for each
+// parallel subtest. Non-parallel subtests use the handler of their parent.
+//
+// As with any parallel Go subtest, the parallel subtests only start once the
+// enclosing test (or subtest) has finished running its other directives, and
+// the parallel subtests of a Go test run concurrently with each other. Their
+// output is spliced back into the correct place in the file when -rewrite is
+// used. Unlike regular subtests, parallel subtests support t.Skip(); the
+// directives of a skipped subtest are left unchanged by -rewrite.
+func RunTestParallel(
+	t *testing.T, path string, newHandler func(t *testing.T) func(t *testing.T, d *TestData) string,
+) {
+	t.Helper()
+	RunTestParallelAny(t, path, func(t testing.TB) func(t testing.TB, d *TestData) string {
+		f := newHandler(t.(*testing.T))
+		return func(t testing.TB, d *TestData) string {
+			return f(t.(*testing.T), d)
+		}
+	})
+}
+
+// RunTestParallelAny is like RunTestParallel but works over a testing.TB. If
+// the testing.TB does not support Parallel(), as is the case for a
+// *testing.B, the parallel subtests are run sequentially, in place, each
+// still with its own handler obtained from newHandler.
+func RunTestParallelAny(
+	t testing.TB, path string, newHandler func(t testing.TB) func(t testing.TB, d *TestData) string,
+) {
+	t.Helper()
+	runTestFile(t, path, newHandler(t), newHandler, nil /* opts */)
+}
+
+func runTestFile(
+	t testing.TB,
+	path string,
+	f func(t testing.TB, d *TestData) string,
+	newHandler func(t testing.TB) func(testing.TB, *TestData) string,
+	opts []RunOption,
+) {
+	t.Helper()
+
+	o := newRunOptions(opts)
 	mode := os.O_RDONLY
-	if *rewriteTestFiles {
+	if o.rewriting(path) {
 		// We only open read-write if rewriting, so as to enable running
 		// tests on read-only copies of the source tree.
 		mode = os.O_RDWR
@@ -142,18 +302,34 @@ func RunTestAny(t testing.TB, path string, f func(t testing.TB, d *TestData) str
 		t.Fatalf("%s is a directory, not a file; consider using datadriven.Walk", path)
 	}
 
-	rewriteData := runTestInternal(t, path, file, f, *rewriteTestFiles)
-	if *rewriteTestFiles {
-		if _, err := file.WriteAt(rewriteData, 0); err != nil {
-			t.Fatal(err)
-		}
-		if err := file.Truncate(int64(len(rewriteData))); err != nil {
-			t.Fatal(err)
-		}
-		if err := file.Sync(); err != nil {
+	r := newTestDataReader(t, path, file, o.rewriting(path))
+	r.opts = o
+	r.accept = o.accepting()
+	r.newHandler = newHandler
+	runTest(t, r, f, func(rewriteData []byte) {
+		if err := writeTestFile(path, rewriteData); err != nil {
 			t.Fatal(err)
 		}
+	})
+}
+
+// writeTestFile overwrites the existing test file at path with the given
+// data.
+func writeTestFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644 /* irrelevant */)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(len(data))); err != nil {
+		return err
 	}
+	return file.Sync()
 }
 
 // RunTestFromString is a version of RunTest which takes the contents of a test
@@ -177,23 +353,89 @@ func runTestInternal(
 	reader io.Reader,
 	f func(t testing.TB, d *TestData) string,
 	rewrite bool,
+	opts ...RunOption,
 ) (rewriteOutput []byte) {
 	t.Helper()
 
 	r := newTestDataReader(t, sourceName, reader, rewrite)
+	if len(opts) > 0 {
+		// A test run from a string is rewritten according to the rewrite
+		// argument only.
+		r.opts = newRunOptions(append(opts, Rewrite(rewrite)))
+	}
+	// There is no newHandler, so writeBack is called before runTest
+	// returns.
+	runTest(t, r, f, func(rewriteData []byte) {
+		rewriteOutput = rewriteData
+	})
+	return rewriteOutput
+}
+
+// runTest runs all the directives read by r. If r records the rewrite
+// output, the rewritten file is passed to writeBack once complete. When the
+// file contains parallel subtests, this only happens after these subtests
+// have finished, i.e. after runTest has returned; writeBack is not called
+// at all in that case if the test fails.
+//
+// The BeforeFile and AfterFile hooks of r.opts are called around the file.
+func runTest(
+	t testing.TB,
+	r *testDataReader,
+	f func(t testing.TB, d *TestData) string,
+	writeBack func(rewriteData []byte),
+) {
+	t.Helper()
+
+	if o := r.opts; o != nil {
+		for _, before := range o.beforeFile {
+			before(t, r.sourceName)
+		}
+		if len(o.afterFile) > 0 {
+			defer r.afterFile(t, func() {
+				for _, after := range o.afterFile {
+					after(t, r.sourceName)
+				}
+			})
+		}
+	}
+	defer r.afterFile(t, func() {
+		if err := flushCoverage(); err != nil {
+			t.Errorf("%s: %v", r.sourceName, err)
+		}
+	})
+
 	for r.Next(t) {
 		runDirectiveOrSubTest(t, r, "" /*mandatorySubTestPrefix*/, f)
 	}
 
-	if r.rewrite != nil {
-		data := r.rewrite.Bytes()
-		// Remove any trailing blank line.
-		if l := len(data); l > 2 && data[l-1] == '\n' && data[l-2] == '\n' {
-			data = data[:l-1]
+	if r.rewrite == nil {
+		return
+	}
+	finish := func() {
+		writeBack(r.rewriteOutput())
+		// The mismatches rejected in -datadriven-accept mode, including
+		// those of the subtests, fail the file's test.
+		r.rejected.report(t)
+	}
+	if !r.startedParallel {
+		finish()
+		return
+	}
+	t.Cleanup(func() {
+		if !t.Failed() {
+			finish()
 		}
-		return data
+	})
+}
+
+// afterFile calls f once the file read by r has run, including its parallel
+// subtests. It is deferred by runTest.
+func (r *testDataReader) afterFile(t testing.TB, f func()) {
+	if r.startedParallel {
+		t.Cleanup(f)
+	} else {
+		f()
 	}
-	return nil
 }
 
 // runDirectiveOrSubTest runs either a "subtest" directive or an
@@ -206,8 +448,18 @@ func runDirectiveOrSubTest(
 	f func(testing.TB, *TestData) string,
 ) {
 	t.Helper()
-	if subTestName, ok := isSubTestStart(t, r, mandatorySubTestPrefix); ok {
-		runSubTest(subTestName, t, r, f)
+	if subTestName, parallel, ok := isSubTestStart(t, r, mandatorySubTestPrefix); ok {
+		switch {
+		case parallel && r.newHandler != nil && canRunParallel(t):
+			runParallelSubTest(subTestName, t, r)
+		case parallel && r.newHandler != nil:
+			// The subtest is run sequentially, but still with its own state.
+			runSubTest(subTestName, t, r, r.newHandler)
+		default:
+			runSubTest(subTestName, t, r, func(testing.TB) func(testing.TB, *TestData) string {
+				return f
+			})
+		}
 	} else {
 		runDirective(t, r, f)
 	}
@@ -223,9 +475,13 @@ func runDirectiveOrSubTest(
 // end`. The opening `subtest` directive has been consumed already.
 // The first parameter `subTestName` is the full path to the subtest,
 // including the parent subtest names as prefix. This is used to
-// validate the nesting and thus prevent mistakes.
+// validate the nesting and thus prevent mistakes. The handler of the
+// directives is obtained by calling newHandler with the Go subtest.
 func runSubTest(
-	subTestName string, t testing.TB, r *testDataReader, f func(testing.TB, *TestData) string,
+	subTestName string,
+	t testing.TB,
+	r *testDataReader,
+	newHandler func(t testing.TB) func(testing.TB, *TestData) string,
 ) {
 	// Remember the current reader position in case we need to spell out
 	// an error message below.
@@ -252,6 +508,7 @@ func runSubTest(
 			}
 		}()
 
+		f := newHandler(t)
 		for r.Next(t) {
 			if isSubTestEnd(t, r) {
 				seenSubTestEnd = true
@@ -273,10 +530,8 @@ func runSubTest(
 			"cannot use t.Skip inside subtest\n%s: subtest started here", subTestStartPos)
 	}
 
-	if seenSubTestEnd && len(r.data.CmdArgs) == 2 && r.data.CmdArgs[1].Key != subTestName {
-		// If a subtest name was provided after "subtest end", ensure that it matches.
-		r.data.Fatalf(t,
-			"mismatched subtest end directive: expected %q, got %q", r.data.CmdArgs[1].Key, subTestName)
+	if seenSubTestEnd {
+		checkSubTestEndName(t, r, subTestName)
 	}
 
 	if !seenSubTestEnd && !t.Failed() {
@@ -288,11 +543,78 @@ func runSubTest(
 
 }
 
-func isSubTestStart(t testing.TB, r *testDataReader, mandatorySubTestPrefix string) (string, bool) {
+// runParallelSubTest runs a subtest declared with "subtest <name> parallel"
+// in a parallel Go subtest. The opening directive has been consumed already.
+//
+// The rest of the file is processed concurrently with the subtest, so the
+// subtest can't share the reader. Instead, its directives are read ahead of
+// time and run from a separate reader, using a handler obtained from
+// r.newHandler. A chunk of the rewrite output is reserved for the subtest and
+// filled in when the subtest completes.
+func runParallelSubTest(subTestName string, t testing.TB, r *testDataReader) {
+	t.Helper()
+
+	subTestStartPos := r.data.Pos
+	startLine := r.scanner.line
+	raw, ok := r.readSubTest(t)
+	if !ok {
+		r.data.Fatalf(t,
+			"EOF encountered without subtest end directive\n%s: subtest started here", subTestStartPos)
+	}
+	checkSubTestEndName(t, r, subTestName)
+
+	sr := r.newSubTestReader(t, startLine, raw)
+	var reserved *bytes.Buffer
+	if r.rewrite != nil {
+		reserved = r.rewrite.Reserve()
+	}
+	r.startedParallel = true
+
+	testingSubTestName := subTestName[strings.LastIndex(subTestName, "/")+1:]
+	subTest(t, testingSubTestName, func(t testing.TB) {
+		t.(interface{ Parallel() }).Parallel()
+		if reserved != nil {
+			// The cleanup runs once the nested subtests, which may be
+			// parallel themselves, have completed.
+			t.Cleanup(func() {
+				if t.Skipped() {
+					// Keep the directives of a skipped subtest as-is.
+					reserved.WriteString(raw)
+				} else {
+					reserved.Write(sr.rewrite.Bytes())
+				}
+			})
+		}
+
+		f := r.newHandler(t)
+		for sr.Next(t) {
+			if isSubTestEnd(t, sr) {
+				return
+			}
+			runDirectiveOrSubTest(t, sr, subTestName+"/" /*mandatorySubTestPrefix*/, f)
+		}
+	})
+}
+
+// canRunParallel returns true if t supports running in parallel.
+func canRunParallel(t testing.TB) bool {
+	_, ok := t.(interface{ Parallel() })
+	return ok
+}
+
+// isSubTestStart checks whether the current directive starts a subtest, and
+// returns the name of the subtest and whether it was declared as parallel.
+func isSubTestStart(
+	t testing.TB, r *testDataReader, mandatorySubTestPrefix string,
+) (name string, parallel bool, ok bool) {
 	if r.data.Cmd != "subtest" {
-		return "", false
+		return "", false, false
 	}
-	if len(r.data.CmdArgs) != 1 {
+	switch {
+	case len(r.data.CmdArgs) == 1:
+	case len(r.data.CmdArgs) == 2 && r.data.CmdArgs[1].String() == "parallel":
+		parallel = true
+	default:
 		r.data.Fatalf(t, "invalid syntax for subtest")
 	}
 	subTestName := r.data.CmdArgs[0].Key
@@ -302,7 +624,16 @@ func isSubTestStart(t testing.TB, r *testDataReader, mandatorySubTestPrefix stri
 	if !strings.HasPrefix(subTestName, mandatorySubTestPrefix) {
 		r.data.Fatalf(t, "name of nested subtest must begin with %q", mandatorySubTestPrefix)
 	}
-	return subTestName, true
+	return subTestName, parallel, true
+}
+
+// checkSubTestEndName verifies that the name provided after "subtest end", if
+// any, matches the name of the subtest.
+func checkSubTestEndName(t testing.TB, r *testDataReader, subTestName string) {
+	if len(r.data.CmdArgs) == 2 && r.data.CmdArgs[1].Key != subTestName {
+		r.data.Fatalf(t,
+			"mismatched subtest end directive: expected %q, got %q", r.data.CmdArgs[1].Key, subTestName)
+	}
 }
 
 func isSubTestEnd(t testing.TB, r *testDataReader) bool {
@@ -328,20 +659,87 @@ func runDirective(t testing.TB, r *testDataReader, f func(testing.TB, *TestData)
 	t.Helper()
 
 	d := &r.data
-	actual := func() string {
+	var retryFor time.Duration
+	var retry bool
+	if r.opts.handlesRetryArg() {
+		retryFor, retry = stripRetryArg(t, d)
+	}
+	cmp := stripComparisonArg(t, d, r.opts)
+	if r.opts.handlesErrorArgs() {
+		d.errorArgs = stripErrorArgs(t, d)
+	}
+	recordDirectiveCoverage(d)
+	// actual is set once the test function has returned.
+	var actual string
+	if o := r.opts; o != nil {
+		for _, before := range o.beforeDirective {
+			before(t, d)
+		}
+		if len(o.afterDirective) > 0 {
+			defer func() {
+				for _, after := range o.afterDirective {
+					after(t, d, actual)
+				}
+			}()
+		}
+	}
+	// out collects the output written to d.Out by the current call of f.
+	var out *outputBuffer
+	run := func() string {
+		out = &outputBuffer{}
+		d.Out = out
+		results := f(t, d)
+		actual := r.opts.scrub(out.String() + results)
+		if actual != "" && !strings.HasSuffix(actual, "\n") {
+			actual += "\n"
+		}
+		return actual
+	}
+	actual = func() string {
+		returned := false
 		defer func() {
 			if r := recover(); r != nil {
-				t.Logf("\npanic during %s:\n%s\n", d.Pos, d.Input)
+				t.Logf("\npanic during %s:\n%s\n%s", d.Pos, d.Input, out.partial())
 				panic(r)
 			}
+			if !returned && !t.Skipped() {
+				// The test was stopped by t.Fatal or t.FailNow.
+				t.Logf("\nfatal error during %s:\n%s\n%s", d.Pos, d.Input, out.partial())
+			}
 		}()
-		actual := f(t, d)
-		if actual != "" && !strings.HasSuffix(actual, "\n") {
-			actual += "\n"
+		if !retry {
+			actual := run()
+			returned = true
+			return actual
 		}
+		// The function can set the clock used for retrying (see WithClock),
+		// so it is called once before the clock is needed. Its output is the
+		// first attempt, except in rewrite mode, where it is only used after
+		// sleeping.
+		first, calls := run(), 0
+		retried := func() string {
+			if calls++; calls == 1 && !d.Rewrite {
+				return first
+			}
+			return run()
+		}
+		var actual string
+		if _, ok := cmp.(exactComparison); ok {
+			actual = d.RetryFor(t, retryFor, retried)
+		} else {
+			actual = d.RetryWith(t, RetryOptions{
+				Timeout: retryFor,
+				Compare: func(_, actual string) bool { return cmp.matches(actual) },
+			}, retried)
+		}
+		returned = true
 		return actual
 	}()
 
+	if d.errorArgs != nil {
+		d.errorArgs.checkError(t, d, actual)
+	}
+
 	if t.Failed() {
 		// If the test has failed with .Error(), then we can't hope it
 		// will have produced a useful actual output. Trying to do
@@ -355,7 +753,16 @@ func runDirective(t testing.TB, r *testDataReader, f func(testing.TB, *TestData)
 
 	// The test has not failed, we can analyze the expected
 	// output.
-	if r.rewrite != nil {
+	matches := cmp.matches(actual)
+	if r.accept && (matches || !acceptMismatch(d, actual)) {
+		// Keep the expected results as they were.
+		r.emitRaw(r.rawExpected)
+		if !matches {
+			// The test file fails once it has been rewritten, see runTest.
+			r.rejected.add(d.Pos, d.Expected, actual)
+		}
+	} else if r.rewrite != nil {
+		actual := cmp.rewrite(actual)
 		r.emit("----")
 		if hasBlankLine(actual) {
 			r.emit("----")
@@ -367,13 +774,16 @@ func runDirective(t testing.TB, r *testDataReader, f func(testing.TB, *TestData)
 			// Here actual already ends in \n so emit adds a blank line.
 			r.emit(actual)
 		}
-	} else if d.Expected != actual {
+	} else if !matches {
+		if explanation := cmp.explain(actual); explanation != "" {
+			t.Fatalf("\n%s:\n %s\noutput didn't match expected:\n%s", d.Pos, d.Input, explanation)
+		}
 		expectedLines := difflib.SplitLines(d.Expected)
 		actualLines := difflib.SplitLines(actual)
-		if len(expectedLines) > 5 {
+		if diffLines := r.opts.diffLines(); diffLines >= 0 && len(expectedLines) > 5 {
 			// Print a unified diff if there is a lot of output to compare.
 			diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-				Context: 5,
+				Context: diffLines,
 				A:       expectedLines,
 				B:       actualLines,
 			})
@@ -384,110 +794,17 @@ func runDirective(t testing.TB, r *testDataReader, f func(testing.TB, *TestData)
 			t.Logf("Failed to produce diff %v", err)
 		}
 		t.Fatalf("\n%s:\n %s\nexpected:\n%s\nfound:\n%s", d.Pos, d.Input, d.Expected, actual)
-	} else if Verbose() {
+	} else if r.opts.verbose() {
 		input := d.Input
 		if input == "" {
 			input = "<no input to command>"
 		}
 		// TODO(tbg): it's awkward to reproduce the args, but it would be helpful.
-		t.Logf("\n%s:\n%s [%d args]\n%s\n----\n%s", d.Pos, d.Cmd, len(d.CmdArgs), input, actual)
+		logVerbose(t, "\n%s:\n%s [%d args]\n%s\n----\n%s", d.Pos, d.Cmd, len(d.CmdArgs), input, actual)
 	}
 	return
 }
 
-// Walk goes through all the files in a subdirectory, creating subtests to match
-// the file hierarchy; for each "leaf" file, the given function is called.
-//
-// This can be used in conjunction with RunTest. For example:
-//
-//	 datadriven.Walk(t, path, func (t *testing.T, path string) {
-//	   // initialize per-test state
-//	   datadriven.RunTest(t, path, func (t *testing.T, d *datadriven.TestData) string {
-//	    // ...
-//	   }
-//	 }
-//
-//	Files:
-//	  testdata/typing
-//	  testdata/logprops/scan
-//	  testdata/logprops/select
-//
-// If path is "testdata/typing", the function is called once and no subtests
-// are created.
-//
-// If path is "testdata/logprops", the function is called two times, in
-// separate subtests /scan, /select.
-//
-// If path is "testdata", the function is called three times, in subtest
-// hierarchy /typing, /logprops/scan, /logprops/select.
-func Walk(t *testing.T, path string, f func(t *testing.T, path string)) {
-	t.Helper()
-	WalkAny(t, path, func(t testing.TB, path string) {
-		f(t.(*testing.T), path)
-	})
-}
-
-// WalkAny is like Walk but works over a testing.TB.
-func WalkAny(t testing.TB, path string, f func(t testing.TB, path string)) {
-	finfo, err := os.Stat(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !finfo.IsDir() {
-		f(t, path)
-		return
-	}
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		t.Fatal(err)
-	}
-	for _, file := range files {
-		if tempFileRe.MatchString(file.Name()) {
-			// Temp or hidden file, don't even try processing.
-			continue
-		}
-		subTest(t, cutExt(file.Name()), func(t testing.TB) {
-			WalkAny(t, filepath.Join(path, file.Name()), f)
-		})
-	}
-}
-
-// cutExt returns the given file name with the extension removed, if there is
-// one.
-func cutExt(fileName string) string {
-	extStart := len(fileName) - len(filepath.Ext(fileName))
-	return fileName[:extStart]
-}
-
-func ClearResults(path string) error {
-	file, err := os.OpenFile(path, os.O_RDWR, 0644 /* irrelevant */)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = file.Close()
-	}()
-	finfo, err := file.Stat()
-	if err != nil {
-		return err
-	}
-
-	if finfo.IsDir() {
-		return fmt.Errorf("%s is a directory, not a file", path)
-	}
-
-	runTestInternal(
-		&testing.T{}, path, file,
-		func(t testing.TB, d *TestData) string { return "" },
-		true, /* rewrite */
-	)
-
-	return nil
-}
-
-// Ignore files named .XXXX, XXX~ or #XXX#.
-var tempFileRe = regexp.MustCompile(`(^\..*)|(.*~$)|(^#.*#$)`)
-
 // TestData contains information about one data-driven test case that was
 // parsed from the test file.
 type TestData struct {
@@ -513,6 +830,20 @@ type TestData struct {
 
 	// Rewrite is set if the test is being run with the -rewrite flag.
 	Rewrite bool
+
+	// Clock, if set, is the source of time for Retry and its variants, which
+	// otherwise use the real time. See WithClock.
+	Clock Clock
+
+	// Out is an alternative to the string returned by the test function: the
+	// actual results are the output written to Out, followed by the returned
+	// string. Out is buffered by the framework and is safe for concurrent use.
+	// If the test function panics or calls t.Fatal, the output written so far
+	// is logged along with the input.
+	Out io.Writer
+
+	// errorArgs are the arguments handled by RunTestErr, if it is used.
+	errorArgs *errorArgs
 }
 
 // HasArg checks whether the CmdArgs array contains an entry for the given key.
@@ -524,6 +855,7 @@ func (td *TestData) HasArg(key string) bool {
 // Arg retrieves the first CmdArg matching the given key. The second return
 // value indicates whether such an argument exists.
 func (td *TestData) Arg(key string) (arg CmdArg, ok bool) {
+	recordCoverage(CoverageRead, td.Cmd, key)
 	for i := range td.CmdArgs {
 		if td.CmdArgs[i].Key == key {
 			return td.CmdArgs[i], true
@@ -569,53 +901,6 @@ func (td *TestData) ScanArgs(t testing.TB, key string, dests ...interface{}) {
 	arg.scan(t, td.Pos, dests...)
 }
 
-// Retry is used for tests that depend on background goroutines to finish work.
-// It takes a function that produces the output of the testcase and calls it
-// repeatedly until it matches the expected output (for at most 1 second).
-//
-// Returns the last value returned by f (which can be directly returned from the
-// function passed to RunTest).
-//
-// If --rewrite is used, just sleeps for 100ms.
-func (td *TestData) Retry(tb testing.TB, f func() string) string {
-	return td.RetryFor(tb, time.Second, f)
-}
-
-// RetryFor is like Retry but with a custom timeout.
-func (td *TestData) RetryFor(tb testing.TB, d time.Duration, f func() string) string {
-	if td.Rewrite {
-		// For rewrite mode, we have nothing to compare the output to. Just sleep a
-		// reasonable amount, under the assumption that --rewrite won't be used
-		// under stress or a loaded system.
-		time.Sleep(d / 10)
-		return f()
-	}
-	runtime.Gosched()
-	// We are going to evaluate f until it produces the correct answer numStable
-	// times in a row.
-	const numAttempts = 100
-	const numStable = 3
-	// numOk is the number of consecutive calls of f() that have returned the
-	// correct answer.
-	numOk := 0
-	expected := strings.TrimSpace(td.Expected)
-	for i := 0; ; i++ {
-		s := f()
-		if strings.TrimSpace(s) == expected {
-			numOk++
-		} else {
-			numOk = 0
-		}
-		if numOk == numStable || i == numAttempts {
-			if i >= numStable {
-				td.Logf(tb, "retried for %s (%d times)", time.Duration(i-numStable+1)*d/numAttempts, i-numStable+1)
-			}
-			return s
-		}
-		time.Sleep(d/numAttempts + 1)
-	}
-}
-
 // CmdArg contains information about an argument on the directive line. An
 // argument is specified in one of the following forms:
 //   - argument
@@ -848,3 +1133,37 @@ func hasBlankLine(s string) bool {
 // want that final match to be included, so we force the end-of-line
 // match using "\n" specifically.
 var blankLineRe = regexp.MustCompile(`(?m)^[\t ]*\n`)
+
+// outputBuffer is the TestData.Out of a call of the test function.
+type outputBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// partial formats the output written so far, for a test function which did
+// not return; b can be nil if the test function was never called.
+func (b *outputBuffer) partial() string {
+	if b == nil {
+		return ""
+	}
+	s := b.String()
+	if s == "" {
+		return ""
+	}
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return "partial output:\n" + s
+}
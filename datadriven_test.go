@@ -15,17 +15,25 @@
 package datadriven
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/pmezard/go-difflib/difflib"
@@ -81,111 +89,1568 @@ xx a=b b=c c=(1,2,3)
 	})
 }
 
+func TestParseFile(t *testing.T) {
+	RunTest(t, "testdata/parse", func(t *testing.T, d *TestData) string {
+		input := strings.ReplaceAll(d.Input, "~~~~", "----") + "\n"
+		f, err := ParseFile(strings.NewReader(input))
+		var buf bytes.Buffer
+		var print func(nodes []Node, indent string)
+		print = func(nodes []Node, indent string) {
+			for _, n := range nodes {
+				s := n.Span()
+				fmt.Fprintf(&buf, "%s", indent)
+				switch n := n.(type) {
+				case *Comment:
+					fmt.Fprintf(&buf, "comment %d-%d [%d-%d]: %s\n",
+						s.StartLine, s.EndLine, s.StartOffset, s.EndOffset, n.Text)
+				case *Blank:
+					fmt.Fprintf(&buf, "blank %d-%d [%d-%d]\n",
+						s.StartLine, s.EndLine, s.StartOffset, s.EndOffset)
+				case *Directive:
+					cmd := n.Cmd
+					if cmd == "" {
+						cmd = "<unparsed>"
+					} else {
+						cmd = fmt.Sprintf("%s %v", n.Cmd, n.CmdArgs)
+					}
+					fmt.Fprintf(&buf, "directive %d-%d [%d-%d]: %s input=%q expected=%q",
+						s.StartLine, s.EndLine, s.StartOffset, s.EndOffset, cmd, n.Input, n.Expected)
+					if !n.HasSeparator() && n.Cmd != "subtest" {
+						fmt.Fprintf(&buf, " (no separator)")
+					}
+					fmt.Fprintln(&buf)
+				case *Subtest:
+					fmt.Fprintf(&buf, "subtest %d-%d [%d-%d]: %s",
+						s.StartLine, s.EndLine, s.StartOffset, s.EndOffset, n.Name)
+					if n.Parallel {
+						fmt.Fprintf(&buf, " parallel")
+					}
+					fmt.Fprintln(&buf)
+					print(append([]Node{n.Start}, n.Nodes...), indent+"  ")
+					if n.End != nil {
+						print([]Node{n.End}, indent+"  ")
+					}
+				}
+			}
+		}
+		if f != nil {
+			print(f.Nodes, "")
+		}
+		if errs, ok := err.(ParseErrors); ok {
+			for _, err := range errs {
+				fmt.Fprintf(&buf, "error: %s\n", err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	})
+}
+
+func TestLintFile(t *testing.T) {
+	RunTest(t, "testdata/lint", func(t *testing.T, d *TestData) string {
+		input := strings.ReplaceAll(d.Input, "~~~~", "----") + "\n"
+		problems, err := LintFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		for _, p := range problems {
+			fmt.Fprintf(&buf, "%s\n", p)
+		}
+		return buf.String()
+	})
+}
+
+func TestFormat(t *testing.T) {
+	RunTest(t, "testdata/format", func(t *testing.T, d *TestData) string {
+		input := strings.ReplaceAll(d.Input, "~~~~", "----") + "\n"
+		f, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Inspect(func(n Node) bool {
+			switch n := n.(type) {
+			case *Comment:
+				if arg, ok := d.Arg("comment"); ok {
+					n.Text = arg.SingleVal(t)
+				}
+			case *Subtest:
+				if arg, ok := d.Arg("rename-subtest"); ok && n.Name == arg.Vals[0] {
+					n.Name = arg.Vals[1]
+				}
+			case *Directive:
+				if arg, ok := d.Arg("add-arg"); ok && n.Cmd == arg.Vals[0] {
+					cmd, args, err := ParseLine("cmd " + arg.Vals[1])
+					if err != nil || cmd != "cmd" {
+						t.Fatal(err)
+					}
+					n.CmdArgs = append(n.CmdArgs, args...)
+				}
+				if arg, ok := d.Arg("set-expected"); ok && n.Cmd == arg.Vals[0] {
+					n.Expected = strings.ReplaceAll(arg.Vals[1], `\n`, "\n")
+				}
+				if arg, ok := d.Arg("rename"); ok && n.Cmd == arg.Vals[0] {
+					n.Cmd = arg.Vals[1]
+				}
+				if d.HasArg("clear-expected") && n.Cmd != "subtest" {
+					n.Expected = ""
+				}
+			}
+			return true
+		})
+		var buf bytes.Buffer
+		if err := Format(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+		return strings.ReplaceAll(buf.String(), "----", "~~~~")
+	})
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	check := func(t *testing.T, input string) {
+		f, err := ParseFile(strings.NewReader(input))
+		if _, ok := err.(ParseErrors); err != nil && !ok {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Format(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != input {
+			t.Errorf("expected:\n%q\nfound:\n%q", input, buf.String())
+		}
+	}
+	for _, input := range []string{
+		"",
+		"cmd\r\n----\r\noutput\r\n\r\n# Comment\r\n",
+		"cmd\n----\noutput",
+		"cmd\ninput",
+		"subtest foo\n",
+	} {
+		check(t, input)
+	}
+	if err := filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		t.Run(path, func(t *testing.T) {
+			check(t, string(data))
+		})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubtestWithoutStart(t *testing.T) {
+	parsed, err := ParseFile(strings.NewReader("# Comment.\ncmd\n----\noutput\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Subtest{Name: "a", Nodes: parsed.Nodes}
+	f := &File{Nodes: []Node{s, &Subtest{Name: "b"}}}
+
+	// The span of the subtest is that of its nodes.
+	if span, expected := s.Span(), (Span{StartLine: 1, EndLine: 4, EndOffset: 27}); span != expected {
+		t.Errorf("expected span %+v, found %+v", expected, span)
+	}
+	if span := f.Nodes[1].Span(); span != (Span{}) {
+		t.Errorf("expected an empty span, found %+v", span)
+	}
+	var nodes []string
+	f.Inspect(func(n Node) bool {
+		nodes = append(nodes, fmt.Sprintf("%T", n))
+		return true
+	})
+	const expected = "*datadriven.Subtest *datadriven.Comment *datadriven.Directive *datadriven.Subtest"
+	if s := strings.Join(nodes, " "); s != expected {
+		t.Errorf("expected %s, found %s", expected, s)
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	const formatted = "subtest a\n# Comment.\ncmd\n----\noutput\nsubtest end\nsubtest b\nsubtest end\n"
+	if buf.String() != formatted {
+		t.Errorf("expected:\n%q\nfound:\n%q", formatted, buf.String())
+	}
+}
+
+func TestDirectiveLine(t *testing.T) {
+	f, err := ParseFile(strings.NewReader("cmd  a=1 \\\n  b=(2,3)  c=(x y) d=(1)\n----\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "cmd a=1 b=(2, 3) c=(x y) d=1"
+	if line := f.Nodes[0].(*Directive).Line(); line != expected {
+		t.Errorf("expected %q, found %q", expected, line)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	canonicalize := func(t *testing.T, input string) string {
+		f, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		Canonicalize(f)
+		var buf bytes.Buffer
+		if err := Format(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	// directives returns the directives of a file, with the fields that
+	// must be preserved by Canonicalize.
+	directives := func(t *testing.T, input string) []string {
+		f, err := ParseFile(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		f.Inspect(func(n Node) bool {
+			if d, ok := n.(*Directive); ok {
+				res = append(res, fmt.Sprintf("%s %v\n%q\n%q", d.Cmd, d.CmdArgs, d.Input, d.Expected))
+			}
+			return true
+		})
+		return res
+	}
+	check := func(t *testing.T, input, expected string) {
+		output := canonicalize(t, input)
+		if expected != "" && output != expected {
+			t.Errorf("expected:\n%q\nfound:\n%q", expected, output)
+		}
+		if again := canonicalize(t, output); again != output {
+			t.Errorf("not idempotent:\n%q\nbecomes:\n%q", output, again)
+		}
+		if a, b := directives(t, input), directives(t, output); !reflect.DeepEqual(a, b) {
+			t.Errorf("directives changed:\n%q\nbecome:\n%q", a, b)
+		}
+	}
+
+	for _, tc := range []struct{ input, expected string }{
+		{"\n\n#  Comment.  \ncmd   a=1  b=(2,3)  \n----\noutput\n\n\n\nlast\n----\n\n\n",
+			"#  Comment.\ncmd a=1 b=(2, 3)\n----\noutput\n\nlast\n----\n"},
+		{"cmd a=1 \\  \n  b=2   \n  input  \r\n----\r\n----\r\noutput\r\n----\r\n----\r\n",
+			"cmd a=1 \\\n  b=2\n  input  \n----\noutput\n"},
+		{"cmd\n----\n----\nblank\n\nline\n----\n----\n\n\nother\n----\n----\n----\n----",
+			"cmd\n----\n----\nblank\n\nline\n----\n----\n\nother\n----\n"},
+		{"cmd\n----\n----\n----\nfoo\n----\n----\n",
+			"cmd\n----\n----\n----\nfoo\n----\n----\n"},
+		{"subtest   foo\n\n\ncmd\n----\nout\n\n\nsubtest end  foo\n\n",
+			"subtest foo\n\ncmd\n----\nout\n\nsubtest end foo\n"},
+		{"cmd\ninput  \n\n\n", "cmd\ninput\n"},
+		{"", ""},
+	} {
+		check(t, tc.input, tc.expected)
+	}
+
+	if err := filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := ParseFile(bytes.NewReader(data)); err != nil {
+			return nil
+		}
+		t.Run(path, func(t *testing.T) {
+			check(t, string(data), "")
+		})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClearResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte(`# Comment.
+cmd
+input
+----
+output
+
+subtest foo
+
+cmd
+----
+----
+output
+
+more output
+----
+----
+
+subtest end
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ClearResults(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `# Comment.
+cmd
+input
+----
+
+subtest foo
+
+cmd
+----
+
+subtest end
+`
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, data)
+	}
+}
+
+func TestClearResultsOptions(t *testing.T) {
+	const input = `cmd  a=1
+----
+output
+
+subtest foo
+
+other
+input
+----
+----
+other
+
+output
+----
+----
+
+subtest foo/bar
+cmd
+----
+nested
+
+subtest end
+
+subtest end
+
+cmd
+----
+last
+`
+	for _, tc := range []struct {
+		opts     []ClearOption
+		expected string
+	}{
+		{
+			opts: []ClearOption{ClearCommands("other")},
+			expected: strings.Replace(input,
+				"----\n----\nother\n\noutput\n----\n----\n", "----\n", 1),
+		},
+		{
+			opts:     []ClearOption{ClearCommands("cmd"), ClearLines(16, 0)},
+			expected: strings.Replace(strings.Replace(input, "nested\n", "", 1), "last\n", "", 1),
+		},
+		{
+			opts:     []ClearOption{ClearLines(3, 3)},
+			expected: strings.Replace(input, "output\n\nsubtest foo\n", "\nsubtest foo\n", 1),
+		},
+		{
+			opts:     []ClearOption{ClearSubtest("foo/bar")},
+			expected: strings.Replace(input, "nested\n", "", 1),
+		},
+		{
+			opts:     []ClearOption{ClearSubtest("bar")},
+			expected: input,
+		},
+	} {
+		path := filepath.Join(t.TempDir(), "test")
+		if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ClearResults(path, tc.opts...); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.expected {
+			t.Errorf("expected:\n%s\nfound:\n%s", tc.expected, data)
+		}
+	}
+}
+
 func TestSkip(t *testing.T) {
 	RunTestFromString(t, `
 skip
 ----
 
-# This error should never happen.
-error
+# This error should never happen.
+error
+----
+`, func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "skip":
+			// Verify that calling t.Skip() does not fail with an API error on
+			// testing.T.
+			t.Skip("woo")
+		case "error":
+			// The skip should mask the error afterwards.
+			t.Error("never reached")
+		}
+		return d.Expected
+	})
+}
+
+func TestSubTest(t *testing.T) {
+	RunTest(t, "testdata/subtest", func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "hello":
+			return d.CmdArgs[0].Key + " was said"
+		case "skip":
+			// Verify that calling t.Skip() does not fail with an API error on
+			// testing.T.
+			t.Skip("woo")
+		case "error":
+			// The skip should mask the error afterwards.
+			t.Error("never reached")
+		default:
+			t.Fatalf("unknown directive: %s", d.Cmd)
+		}
+		return d.Expected
+	})
+}
+
+// newParallelHandler is the handler factory used with testdata/parallel.
+func newParallelHandler(t testing.TB) func(t testing.TB, d *TestData) string {
+	v := "<unset>"
+	return func(t testing.TB, d *TestData) string {
+		switch d.Cmd {
+		case "set":
+			d.ScanArgs(t, "v", &v)
+			return ""
+		case "get":
+			return v
+		case "skip":
+			t.Skip("woo")
+		default:
+			t.Fatalf("unknown directive: %s", d.Cmd)
+		}
+		return d.Expected
+	}
+}
+
+func TestParallelSubTest(t *testing.T) {
+	RunTestParallelAny(t, "testdata/parallel", newParallelHandler)
+}
+
+func TestParallelSubTestSequential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte(`
+set v=root
+----
+
+subtest a parallel
+
+get
+----
+<unset>
+
+set v=a
+----
+
+subtest end
+
+get
+----
+root
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The recordingT does not support Parallel(), so the parallel subtest is
+	// run in place, with its own handler.
+	rt := &recordingT{}
+	rt.Run("", func(rt testing.TB) {
+		RunTestParallelAny(rt, path, newParallelHandler)
+	})
+	if rt.Failed() {
+		t.Errorf("unexpected failure:\n%s", strings.Join(rt.logs, "\n"))
+	}
+}
+
+func TestParallelSubTestRewrite(t *testing.T) {
+	const path = "testdata/parallel"
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mangle the expected output of all the directives, except those in the
+	// skipped subtest which are expected to be preserved.
+	before := strings.NewReplacer(
+		"----\n<unset>\n", "----\nxxx\n",
+		"----\na\n", "----\nyyy\nzzz\n",
+		"----\nb\n", "----\n",
+		"----\nroot\n", "----\n----\nxxx\n\nyyy\n----\n----\n",
+	).Replace(string(expected))
+	if before == string(expected) {
+		t.Fatal("test file was not mangled")
+	}
+
+	var rewritten []byte
+	t.Run("rewrite", func(t *testing.T) {
+		r := newTestDataReader(t, path, strings.NewReader(before), true /* record */)
+		r.newHandler = newParallelHandler
+		runTest(t, r, newParallelHandler(t), func(rewriteData []byte) {
+			rewritten = rewriteData
+		})
+	})
+	// The parallel subtests have completed by the time t.Run returns.
+	if string(rewritten) != string(expected) {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, rewritten)
+	}
+}
+
+func TestMultiLineTest(t *testing.T) {
+	RunTest(t, "testdata/multiline", func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "small":
+			return `just
+two lines of output`
+		case "large":
+			return `more
+than
+five
+lines
+of
+output`
+		default:
+			t.Fatalf("unknown directive: %s", d.Cmd)
+		}
+		return d.Expected
+	})
+}
+
+func TestRetry(t *testing.T) {
+	var v atomic.Uint32
+	RunTest(t, "testdata/retry", func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "inc":
+			n := 1
+			d.MaybeScanArgs(t, "n", &n)
+			for i := 0; i < n; i++ {
+				go func() {
+					time.Sleep(time.Duration(rand.Intn(10)) * time.Microsecond)
+					v.Add(1)
+				}()
+			}
+			return ""
+
+		case "read":
+			return d.Retry(t, func() string {
+				return fmt.Sprint(v.Load())
+			})
+
+		default:
+			t.Fatalf("unknown directive: %s", d.Cmd)
+		}
+		return d.Expected
+	})
+}
+
+func TestRetryWith(t *testing.T) {
+	// containsLines returns true if all the expected lines are present.
+	containsLines := func(expected, actual string) bool {
+		for _, line := range strings.Split(strings.TrimSpace(expected), "\n") {
+			if !strings.Contains(actual, line+"\n") {
+				return false
+			}
+		}
+		return true
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		expected string
+		opts     RetryOptions
+		// output returns the output of the i-th call.
+		output        func(i int) string
+		expectedCalls int
+	}{
+		{name: "default", expected: "5", expectedCalls: 8},
+		{name: "stable", expected: "5", opts: RetryOptions{Stable: 1}, expectedCalls: 6},
+		{name: "max-attempts", expected: "never", opts: RetryOptions{MaxAttempts: 5, Backoff: time.Millisecond},
+			expectedCalls: 6},
+		{
+			name:     "backoff",
+			expected: "never",
+			opts: RetryOptions{
+				Timeout: 10 * time.Millisecond, Backoff: time.Millisecond, BackoffMultiplier: 2,
+			},
+			// Sleeps for 1+2+4+8ms.
+			expectedCalls: 5,
+		},
+		{
+			name:     "max-backoff",
+			expected: "never",
+			opts: RetryOptions{
+				Timeout: 10 * time.Millisecond, Backoff: time.Millisecond, BackoffMultiplier: 2,
+				MaxBackoff: 2 * time.Millisecond,
+			},
+			// Sleeps for 1+2+2+2+2+2ms.
+			expectedCalls: 7,
+		},
+		{
+			name:     "compare",
+			expected: "b\nd\n",
+			opts:     RetryOptions{Compare: containsLines, Stable: 1},
+			output: func(i int) string {
+				return strings.Join(strings.Split("abcdef"[:i+1], ""), "\n") + "\n"
+			},
+			expectedCalls: 4,
+		},
+		{name: "canceled", ctx: canceled, expected: "never", expectedCalls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			output := tc.output
+			if output == nil {
+				output = func(i int) string {
+					if i > 5 {
+						return "5"
+					}
+					return fmt.Sprint(i)
+				}
+			}
+			td := &TestData{Pos: tc.name, Expected: tc.expected}
+			calls := 0
+			td.RetryWithContext(ctx, t, tc.opts, func() string {
+				calls++
+				return output(calls - 1)
+			})
+			if calls != tc.expectedCalls {
+				t.Errorf("expected %d calls, found %d", tc.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestRetryHistory(t *testing.T) {
+	var h retryHistory
+	for i, output := range []string{"a\n", "a\n", "b\nc\n", "a\n", "", ""} {
+		h.add(output, time.Duration(i)*10*time.Millisecond)
+	}
+	const expected = `retry history (4 outputs):
++0s (2 times):
+  a
++20ms (1 time):
+  b
+  c
++30ms (1 time):
+  a
++40ms (2 times):
+  <empty>`
+	if s := h.String(); s != expected {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, s)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	RunTestFromString(t, `
+now
+----
+0s
+
+advance 5s
+----
+
+now
+----
+5s
+
+wait-until 5.5s
+----
+reached
+
+now
+----
+5.5s
+`, WithClock(clock, func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "now":
+			return d.Clock.Now().Sub(start).Round(time.Millisecond).String()
+		case "wait-until":
+			deadline, err := time.ParseDuration(d.CmdArgs[0].Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Each retry advances the clock by 10ms.
+			return d.RetryWith(t, RetryOptions{Stable: 1}, func() string {
+				if clock.Now().Sub(start) < deadline {
+					return "waiting"
+				}
+				return "reached"
+			})
+		}
+		d.Fatalf(t, "unknown command %s", d.Cmd)
+		return ""
+	}))
+}
+
+func TestRetryArg(t *testing.T) {
+	var v atomic.Uint32
+	input := `
+inc n=20
+----
+
+read retry
+----
+20
+
+inc n=5
+----
+
+read retry=5s verbose
+----
+25
+`
+	handler := func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "inc":
+			var n int
+			d.ScanArgs(t, "n", &n)
+			for i := 0; i < n; i++ {
+				go func() {
+					time.Sleep(time.Duration(rand.Intn(10)) * time.Microsecond)
+					v.Add(1)
+				}()
+			}
+			return ""
+
+		case "read":
+			if d.HasArg(retryArg) {
+				t.Errorf("unexpected %s argument", retryArg)
+			}
+			return fmt.Sprint(v.Load())
+		}
+		d.Fatalf(t, "unknown directive: %s", d.Cmd)
+		return ""
+	}
+	anyHandler := func(t testing.TB, d *TestData) string {
+		return handler(t.(*testing.T), d)
+	}
+	runTestInternal(t, "<string>", strings.NewReader(input), anyHandler, *rewriteTestFiles, RetryArg())
+
+	// The argument is kept when rewriting.
+	v.Store(0)
+	rewritten := string(runTestInternal(t, "<string>", strings.NewReader(input), anyHandler,
+		true /* rewrite */, RetryArg()))
+	if !strings.Contains(rewritten, "read retry\n") || !strings.Contains(rewritten, "read retry=5s verbose\n") {
+		t.Errorf("retry argument not kept:\n%s", rewritten)
+	}
+
+	// Without the option, the argument is passed to the function, whatever
+	// its value.
+	RunTestFromString(t, `
+read retry=3
+----
+retry=3
+`, func(t *testing.T, d *TestData) string {
+		return fmt.Sprint(d.CmdArgs[0])
+	})
+}
+
+func TestRetryArgWithClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	realStart := time.Now()
+	runTestInternal(t, "<string>", strings.NewReader(`
+wait-until 5s retry=10s
+----
+reached
+`), WithClockAny(clock, func(t testing.TB, d *TestData) string {
+		deadline, err := time.ParseDuration(d.CmdArgs[0].Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clock.Now().Sub(start) < deadline {
+			return "waiting"
+		}
+		return "reached"
+	}), *rewriteTestFiles, RetryArg())
+	// The retries advanced the manual clock instead of sleeping.
+	if elapsed := clock.Now().Sub(start); elapsed < 5*time.Second {
+		t.Errorf("expected the clock to advance by 5s, found %s", elapsed)
+	}
+	if elapsed := time.Since(realStart); elapsed > 2*time.Second {
+		t.Errorf("expected the retries not to sleep, found %s", elapsed)
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	WalkWithOptions(t, "testdata/compare", func(t *testing.T, path string, opts []RunOption) {
+		RunTestWithOptions(t, path, func(t *testing.T, d *TestData) string {
+			if len(d.CmdArgs) > 0 {
+				t.Errorf("unexpected arguments: %v", d.CmdArgs)
+			}
+			return d.Input
+		}, opts...)
+	}, WalkRunOptions(ComparisonArgs(), RetryArg()))
+
+	// Without the option, the comparison arguments are passed to the
+	// function.
+	RunTestFromString(t, `
+echo unordered json
+b
+a
+----
+unordered json
+`, func(t *testing.T, d *TestData) string {
+		var keys []string
+		for _, arg := range d.CmdArgs {
+			keys = append(keys, arg.Key)
+		}
+		return strings.Join(keys, " ")
+	})
+
+	// Only the given arguments are enabled.
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte("echo unordered json\nb\na\n----\na\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	RunTestWithOptions(t, path, func(t *testing.T, d *TestData) string {
+		if len(d.CmdArgs) != 1 || d.CmdArgs[0].Key != "json" {
+			t.Errorf("unexpected arguments: %v", d.CmdArgs)
+		}
+		return d.Input
+	}, ComparisonArgs("unordered"))
+}
+
+func TestPatternComparison(t *testing.T) {
+	for _, tc := range []struct {
+		expected, actual string
+		matches          bool
+		rewrite          string
+	}{
+		{expected: "", actual: "", matches: true},
+		{expected: "...\n", actual: "", matches: true},
+		{expected: "a\n", actual: "", rewrite: ""},
+		{expected: "re:a+\n", actual: "aaa\nb\n", rewrite: "re:a+\nb\n"},
+		{expected: "a <any> c\n", actual: "a b c\n", matches: true},
+		{expected: "a <any> c\n", actual: "a b d\n", rewrite: "a b d\n"},
+		// Unchanged patterns are kept, and the other lines are replaced.
+		{
+			expected: "re:id=\\d+\nname=foo\n...\nend\n",
+			actual:   "id=12\nname=bar\nx\ny\nend\n",
+			rewrite:  "re:id=\\d+\nname=bar\n...\nend\n",
+		},
+		// The ellipsis keeps standing for the middle of a gap.
+		{
+			expected: "a\n...\nz\n",
+			actual:   "a\nb\nc\ny\n",
+			rewrite:  "a\n...\ny\n",
+		},
+		{
+			expected: "a\nb\n...\nz\n",
+			actual:   "a\nc\n",
+			rewrite:  "a\nc\n...\n",
+		},
+	} {
+		cmp, err := newPatternComparison(CmdArg{Key: "patterns"}, tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := cmp.matches(tc.actual); matches != tc.matches {
+			t.Errorf("%q: expected matches(%q) = %t", tc.expected, tc.actual, tc.matches)
+		}
+		rewrite := cmp.rewrite(tc.actual)
+		if tc.matches {
+			tc.rewrite = tc.expected
+		}
+		if rewrite != tc.rewrite {
+			t.Errorf("%q: expected rewrite(%q) = %q, found %q", tc.expected, tc.actual, tc.rewrite, rewrite)
+		}
+		if !cmp.matches(tc.actual) && rewrite != "" {
+			rcmp, err := newPatternComparison(CmdArg{Key: "patterns"}, rewrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rcmp.matches(tc.actual) {
+				t.Errorf("%q: rewrite %q doesn't match %q", tc.expected, rewrite, tc.actual)
+			}
+		}
+	}
+	if _, err := newPatternComparison(CmdArg{Key: "patterns"}, "re:(\n"); err == nil {
+		t.Errorf("expected an error for an invalid regexp")
+	}
+}
+
+func TestUnorderedComparison(t *testing.T) {
+	for _, tc := range []struct {
+		arg              CmdArg
+		expected, actual string
+		matches          bool
+		rewrite, explain string
+	}{
+		{
+			arg:      CmdArg{Key: "unordered"},
+			expected: "b\na\n", actual: "a\nb\n", matches: true, rewrite: "a\nb\n",
+		},
+		{
+			arg:      CmdArg{Key: "unordered"},
+			expected: "b\na\na\n", actual: "c\na\nb\n", rewrite: "a\nb\nc\n",
+			explain: "missing lines:\n  a\nunexpected lines:\n  c\n",
+		},
+		{
+			arg:      CmdArg{Key: "unordered", Vals: []string{"blocks"}},
+			expected: "b\nc\n\na\n", actual: "a\n\n\nb\nc\n", matches: true, rewrite: "a\n\nb\nc\n",
+		},
+		{
+			arg:      CmdArg{Key: "unordered", Vals: []string{"blocks"}},
+			expected: "a\n\nb\nc\n", actual: "a\n\nc\nb\n", rewrite: "a\n\nc\nb\n",
+			explain: "missing blocks:\n  b\n  c\n\nunexpected blocks:\n  c\n  b\n\n",
+		},
+	} {
+		cmp, err := newUnorderedComparison(tc.arg, tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := cmp.matches(tc.actual); matches != tc.matches {
+			t.Errorf("%q: expected matches(%q) = %t", tc.expected, tc.actual, tc.matches)
+		}
+		if rewrite := cmp.rewrite(tc.actual); rewrite != tc.rewrite {
+			t.Errorf("%q: expected rewrite(%q) = %q, found %q", tc.expected, tc.actual, tc.rewrite, rewrite)
+		}
+		if !tc.matches {
+			if explain := cmp.explain(tc.actual); explain != tc.explain {
+				t.Errorf("%q: expected explain(%q) = %q, found %q", tc.expected, tc.actual, tc.explain, explain)
+			}
+		}
+	}
+	if _, err := newUnorderedComparison(CmdArg{Key: "unordered", Vals: []string{"words"}}, ""); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
+
+func TestJSONComparison(t *testing.T) {
+	for _, tc := range []struct {
+		expected, actual string
+		matches          bool
+		rewrite, explain string
+	}{
+		{
+			expected: `{"a": 1, "b": [true, null]}`,
+			actual:   `{"b":[true,null],"a":1.0}`,
+			matches:  true,
+			rewrite:  "{\n  \"a\": 1.0,\n  \"b\": [\n    true,\n    null\n  ]\n}\n",
+		},
+		{
+			expected: `{"a": {"b": [1, 2], "c": "x"}, "d": 1, "e f": 2}`,
+			actual:   `{"a": {"b": [1, 3, 4]}, "e f": 3, "g": "<y>"}`,
+			rewrite:  "{\n  \"a\": {\n    \"b\": [\n      1,\n      3,\n      4\n    ]\n  },\n  \"e f\": 3,\n  \"g\": \"<y>\"\n}\n",
+			explain: `$.a.b[1]: expected 2, found 3
+$.a.b[2]: unexpected 4
+$.a.c: missing
+$.d: missing
+$["e f"]: expected 2, found 3
+$.g: unexpected "<y>"
+`,
+		},
+		{
+			expected: `[1]`,
+			actual:   `{}`,
+			rewrite:  "{}\n",
+			explain:  "$: expected [1], found {}\n",
+		},
+		{
+			expected: ``,
+			actual:   `{}`,
+			rewrite:  "{}\n",
+			explain:  "the expected results are not valid JSON: EOF\n",
+		},
+		{
+			expected: `{}`,
+			actual:   "{} x\n",
+			rewrite:  "{} x\n",
+			explain:  "the actual results are not valid JSON: unexpected data after the JSON value\n{} x\n",
+		},
+	} {
+		cmp, err := newJSONComparison(CmdArg{Key: "json"}, tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := cmp.matches(tc.actual); matches != tc.matches {
+			t.Errorf("%q: expected matches(%q) = %t", tc.expected, tc.actual, tc.matches)
+		}
+		if rewrite := cmp.rewrite(tc.actual); rewrite != tc.rewrite {
+			t.Errorf("%q: expected rewrite(%q) = %q, found %q", tc.expected, tc.actual, tc.rewrite, rewrite)
+		}
+		if !tc.matches {
+			if explain := cmp.explain(tc.actual); explain != tc.explain {
+				t.Errorf("%q: expected explain(%q):\n%s\nfound:\n%s", tc.expected, tc.actual, tc.explain, explain)
+			}
+		}
+	}
+}
+
+func TestDirective(t *testing.T) {
+	RunTest(t, "testdata/directive", func(t *testing.T, d *TestData) string {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "cmd: %s\n%d arguments\n", d.Cmd, len(d.CmdArgs))
+		for _, a := range d.CmdArgs {
+			fmt.Fprintf(&buf, "key=%#v vals=%#v\n", a.Key, a.Vals)
+		}
+		return buf.String()
+	})
+}
+
+func TestWalk(t *testing.T) {
+	Walk(t, "testdata/walk", func(t *testing.T, path string) {
+		RunTest(t, path, func(t *testing.T, d *TestData) string {
+			return fmt.Sprintf("test name: %s\n", t.Name())
+		})
+	})
+}
+
+func TestWalkParallel(t *testing.T) {
+	var running, maxRunning, visited int32
+	t.Run("walk", func(t *testing.T) {
+		Walk(t, "testdata/walk", func(t *testing.T, path string) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for m := atomic.LoadInt32(&maxRunning); n > m; m = atomic.LoadInt32(&maxRunning) {
+				if atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			atomic.AddInt32(&visited, 1)
+			time.Sleep(10 * time.Millisecond)
+			RunTest(t, path, func(t *testing.T, d *TestData) string {
+				return d.Expected
+			})
+		}, WalkParallel(1))
+	})
+	// The parallel subtests have all completed by the time t.Run returns.
+	if visited != 2 {
+		t.Errorf("expected 2 files to be visited, got %d", visited)
+	}
+	if maxRunning != 1 {
+		t.Errorf("expected at most 1 file to run at a time, got %d", maxRunning)
+	}
+}
+
+// writeParallelFiles writes n test files, each with two parallel subtests
+// running the given directive, and returns their directory.
+func writeParallelFiles(t *testing.T, n int, directive string) string {
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file%d", i)
+		data := fmt.Sprintf(`%[1]s
+----
+
+subtest a parallel
+%[1]s
+----
+
+subtest end
+
+subtest b parallel
+%[1]s
+----
+
+subtest end
+`, directive)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runTestSubprocess runs the given test in a subprocess, in verbose mode,
+// with the environment variable subprocessTestEnvVar set to the given value,
+// and returns its output. The test fails if the subprocess does.
+func runTestSubprocess(t *testing.T, name, value string, args ...string) string {
+	out, err := execTestSubprocess(name, value, args...)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	return out
+}
+
+// execTestSubprocess is like runTestSubprocess, but returns the error of the
+// subprocess, for the tests which are expected to fail.
+func execTestSubprocess(name, value string, args ...string) (string, error) {
+	args = append([]string{"-test.run=^" + name + "$", "-test.v"}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), subprocessTestEnvVar+"="+value, "DATADRIVEN_QUIET_LOG=false")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// subprocessTestEnvVar is set when a test runs itself in a subprocess, to
+// the directory of its test files.
+const subprocessTestEnvVar = "DATADRIVEN_TEST_SUBPROCESS_DIR"
+
+func TestWalkParallelOverlap(t *testing.T) {
+	const maxParallel = 2
+	dir := os.Getenv(subprocessTestEnvVar)
+	if dir == "" {
+		// The number of files running concurrently is also bounded by
+		// -test.parallel, which defaults to the number of CPUs.
+		runTestSubprocess(t, "TestWalkParallelOverlap", writeParallelFiles(t, 3, "run"),
+			"-test.parallel=4")
+		return
+	}
+
+	// The files wait for another file to run at the same time.
+	var running, maxRunning, overlapped int32
+	t.Run("walk", func(t *testing.T) {
+		Walk(t, dir, func(t *testing.T, path string) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for m := atomic.LoadInt32(&maxRunning); n > m; m = atomic.LoadInt32(&maxRunning) {
+				if atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+				if atomic.LoadInt32(&running) > 1 {
+					atomic.AddInt32(&overlapped, 1)
+					break
+				}
+				if atomic.LoadInt32(&overlapped) > 0 {
+					// The other files don't need to wait anymore.
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			RunTest(t, path, func(t *testing.T, d *TestData) string {
+				return ""
+			})
+		}, WalkParallel(maxParallel))
+	})
+	if overlapped == 0 {
+		t.Errorf("expected the files to run concurrently")
+	}
+	if maxRunning > maxParallel {
+		t.Errorf("expected at most %d files to run at a time, got %d", maxParallel, maxRunning)
+	}
+}
+
+func TestWalkParallelLogs(t *testing.T) {
+	if dir := os.Getenv(subprocessTestEnvVar); dir != "" {
+		Walk(t, dir, func(t *testing.T, path string) {
+			RunTestParallel(t, path, func(t *testing.T) func(t *testing.T, d *TestData) string {
+				return func(t *testing.T, d *TestData) string {
+					// Give the other files a chance to log in between.
+					time.Sleep(5 * time.Millisecond)
+					return ""
+				}
+			})
+		}, WalkParallel(3))
+		return
+	}
+
+	// Run the walk in verbose mode in a subprocess, to look at its output.
+	dir := writeParallelFiles(t, 3, "run")
+	out := runTestSubprocess(t, "TestWalkParallelLogs", dir, "-test.parallel=4")
+	// The 3 directives of each file are echoed, as "<path>:<line>:", in one
+	// block: the file of each echo is the file of the previous one, unless
+	// the previous file has been completely echoed.
+	var files []string
+	echoes := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, dir) || !strings.HasSuffix(line, ":") {
+			continue
+		}
+		file := filepath.Base(line[:strings.Index(line[len(dir):], ":")+len(dir)])
+		if n := len(files); n > 0 && files[n-1] != file && echoes[files[n-1]] != 3 {
+			t.Errorf("the echoes of %s are interleaved with those of %s:\n%s", files[n-1], file, out)
+			break
+		}
+		if len(files) == 0 || files[len(files)-1] != file {
+			files = append(files, file)
+		}
+		echoes[file]++
+	}
+	if len(files) != 3 {
+		t.Errorf("expected the echoes of 3 files, found %q:\n%s", files, out)
+	}
+}
+
+func TestWalkFilters(t *testing.T) {
+	walkPaths := func(t *testing.T, path string, opts ...WalkOption) string {
+		var paths []string
+		Walk(t, path, func(f *testing.T, path string) {
+			name := strings.TrimPrefix(f.Name(), t.Name()+"/")
+			paths = append(paths, fmt.Sprintf("%s (%s)", filepath.ToSlash(path), name))
+		}, opts...)
+		return strings.Join(paths, "\n")
+	}
+	RunTestFromString(t, `
+# The ignore file in sub/ excludes sub/skipped.test and sub/c.golden.
+walk
+----
+testdata/walk-filter/README.md (README)
+testdata/walk-filter/a.golden (a)
+testdata/walk-filter/a.test (a#01)
+testdata/walk-filter/b.txt (b)
+testdata/walk-filter/helpers/h.test (helpers/h)
+testdata/walk-filter/noext (noext)
+testdata/walk-filter/sub/c.test (sub/c)
+
+walk exclude=(README.md, *.golden, helpers)
+----
+testdata/walk-filter/a.test (a)
+testdata/walk-filter/b.txt (b)
+testdata/walk-filter/noext (noext)
+testdata/walk-filter/sub/c.test (sub/c)
+
+walk include=(sub/*, *.txt)
+----
+testdata/walk-filter/b.txt (b)
+testdata/walk-filter/sub/c.test (sub/c)
+
+walk ext=(.test, )
+----
+testdata/walk-filter/a.test (a)
+testdata/walk-filter/helpers/h.test (helpers/h)
+testdata/walk-filter/noext (noext)
+testdata/walk-filter/sub/c.test (sub/c)
+
+walk ext=.test keep-ext
+----
+testdata/walk-filter/a.test (a.test)
+testdata/walk-filter/helpers/h.test (helpers/h.test)
+testdata/walk-filter/sub/c.test (sub/c.test)
+`, func(t *testing.T, d *TestData) string {
+		var opts []WalkOption
+		for _, arg := range d.CmdArgs {
+			switch arg.Key {
+			case "exclude":
+				opts = append(opts, WalkExclude(arg.Vals...))
+			case "include":
+				opts = append(opts, WalkInclude(arg.Vals...))
+			case "ext":
+				opts = append(opts, WalkExtensions(arg.Vals...))
+			case "keep-ext":
+				opts = append(opts, WalkSubTestName(func(fileName string) string { return fileName }))
+			}
+		}
+		var out string
+		t.Run("walk", func(t *testing.T) {
+			out = walkPaths(t, "testdata/walk-filter", opts...)
+		})
+		return out
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+			t.Skip(err)
+		}
+		if paths := walkPaths(t, dir); strings.Count(paths, "\n") != 1 {
+			t.Errorf("expected the symlink to be followed, got:\n%s", paths)
+		}
+		if paths := walkPaths(t, dir, WalkFollowSymlinks(false)); strings.Count(paths, "\n") != 0 {
+			t.Errorf("expected the symlink to be skipped, got:\n%s", paths)
+		}
+	})
+}
+
+func TestWalkFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"testdata/a.test":     {Data: []byte("name\n----\nTestWalkFS/a\n")},
+		"testdata/sub/b.test": {Data: []byte("name\n----\nTestWalkFS/sub/b\n")},
+		"testdata/.hidden":    {Data: []byte("not a test file")},
+	}
+	WalkFS(t, fsys, "testdata", func(t *testing.T, path string) {
+		RunTestFS(t, fsys, path, func(t *testing.T, d *TestData) string {
+			return t.Name()
+		})
+	})
+}
+
+func TestRunTestFSRewrite(t *testing.T) {
+	fsys := fstest.MapFS{
+		"testdata/a.test": {Data: []byte("noop\nfoo\n----\nbar\n")},
+	}
+	dir := t.TempDir()
+	defer func(rewrite bool) { *rewriteTestFiles = rewrite }(*rewriteTestFiles)
+	*rewriteTestFiles = true
+	RunTestFS(t, WithRewriteDir(fsys, dir), "testdata/a.test", func(t *testing.T, d *TestData) string {
+		return d.Input
+	})
+
+	data, err := os.ReadFile(filepath.Join(dir, "testdata", "a.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "noop\nfoo\n----\nfoo\n"; string(data) != expected {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, data)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coverage")
+	defer func(file string) { *coverageFile = file }(*coverageFile)
+	*coverageFile = path
+
+	RunTestFromString(t, `
+coverage-a x=1 y=2
+----
+
+coverage-a y=3
+----
+
+coverage-b
+----
+`, func(t *testing.T, d *TestData) string {
+		var y int
+		d.MaybeScanArgs(t, "y", &y)
+		d.HasArg("z")
+		return ""
+	})
+	// The arguments handled by the framework are not recorded.
+	runTestInternal(t, "<string>", strings.NewReader(`
+coverage-c expect-error error-chain retry
+----
+error: boom
+*errors.errorString: boom
+`), errHandler(func(t testing.TB, d *TestData) (string, error) {
+		return "", errors.New("boom")
+	}), *rewriteTestFiles, handleErrorArgs, RetryArg())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{
+		"cmd\tcoverage-a",
+		"arg\tcoverage-a\tx",
+		"arg\tcoverage-a\ty",
+		"read\tcoverage-a\ty",
+		"read\tcoverage-a\tz",
+		"cmd\tcoverage-b",
+		"read\tcoverage-b\ty",
+		"read\tcoverage-b\tz",
+	} {
+		if n := strings.Count(string(data), record+"\n"); n != 1 {
+			t.Errorf("expected record %q once, found it %d times in:\n%s", record, n, data)
+		}
+	}
+	if strings.Contains(string(data), "read\tcoverage-a\tx") {
+		t.Errorf("unexpected read record for x:\n%s", data)
+	}
+	if !strings.Contains(string(data), "cmd\tcoverage-c\n") || strings.Contains(string(data), "arg\tcoverage-c") {
+		t.Errorf("unexpected records for coverage-c:\n%s", data)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte(`echo
+a
+----
+old a
+
+echo
+b
+----
+----
+old
+
+b
+----
+----
+
+echo
+c
+----
+c
+
+echo
+d
+----
+old d
+
+echo
+e
+----
+old e
+
+echo
+f
+----
+----
+f
+----
+----
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(accept bool) { *acceptResults = accept }(*acceptResults)
+	*acceptResults = true
+	var out bytes.Buffer
+	acceptor.in, acceptor.out = bufio.NewReader(strings.NewReader("y\nn\nmaybe\na\n")), &out
+	defer func() {
+		acceptor.in, acceptor.out, acceptor.all, acceptor.quit = nil, nil, false, false
+	}()
+	rt := &recordingT{}
+	rt.Run("", func(rt testing.TB) {
+		RunTestAny(rt, path, func(t testing.TB, d *TestData) string {
+			return d.Input
+		})
+	})
+	// The rejected mismatch fails the test.
+	if !rt.Failed() {
+		t.Errorf("expected a failure")
+	}
+	if logs := strings.Join(rt.logs, "\n"); !strings.Contains(logs, ":6: kept the expected results") ||
+		!strings.Contains(logs, "-old\n") {
+		t.Errorf("expected the rejected mismatch to be reported, found:\n%s", logs)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `echo
+a
+----
+a
+
+echo
+b
+----
+----
+old
+
+b
+----
+----
+
+echo
+c
+----
+c
+
+echo
+d
+----
+d
+
+echo
+e
 ----
-`, func(t *testing.T, d *TestData) string {
-		switch d.Cmd {
-		case "skip":
-			// Verify that calling t.Skip() does not fail with an API error on
-			// testing.T.
-			t.Skip("woo")
-		case "error":
-			// The skip should mask the error afterwards.
-			t.Error("never reached")
-		}
-		return d.Expected
-	})
-}
+e
 
-func TestSubTest(t *testing.T) {
-	RunTest(t, "testdata/subtest", func(t *testing.T, d *TestData) string {
-		switch d.Cmd {
-		case "hello":
-			return d.CmdArgs[0].Key + " was said"
-		case "skip":
-			// Verify that calling t.Skip() does not fail with an API error on
-			// testing.T.
-			t.Skip("woo")
-		case "error":
-			// The skip should mask the error afterwards.
-			t.Error("never reached")
-		default:
-			t.Fatalf("unknown directive: %s", d.Cmd)
-		}
-		return d.Expected
-	})
+echo
+f
+----
+----
+f
+----
+----
+`
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\nfound:\n%s", expected, data)
+	}
+	if n := strings.Count(out.String(), "Accept this change [y,n,a,q]? "); n != 4 {
+		t.Errorf("expected 4 prompts, found %d:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "-old a\n+a\n") {
+		t.Errorf("expected a diff, found:\n%s", out.String())
+	}
 }
 
-func TestMultiLineTest(t *testing.T) {
-	RunTest(t, "testdata/multiline", func(t *testing.T, d *TestData) string {
-		switch d.Cmd {
-		case "small":
-			return `just
-two lines of output`
-		case "large":
-			return `more
-than
-five
-lines
-of
-output`
-		default:
-			t.Fatalf("unknown directive: %s", d.Cmd)
-		}
-		return d.Expected
-	})
-}
+// TestAcceptSubtests checks that the mismatches rejected within the Go
+// subtests of subtest blocks fail the file's test once the accepted results
+// have been written, instead of stopping the review.
+func TestAcceptSubtests(t *testing.T) {
+	const data = `subtest a%s
+echo
+a
+----
+old a
 
-func TestRetry(t *testing.T) {
-	var v atomic.Uint32
-	RunTest(t, "testdata/retry", func(t *testing.T, d *TestData) string {
-		switch d.Cmd {
-		case "inc":
-			n := 1
-			d.MaybeScanArgs(t, "n", &n)
-			for i := 0; i < n; i++ {
-				go func() {
-					time.Sleep(time.Duration(rand.Intn(10)) * time.Microsecond)
-					v.Add(1)
-				}()
-			}
-			return ""
+subtest end
 
-		case "read":
-			return d.Retry(t, func() string {
-				return fmt.Sprint(v.Load())
+echo
+b
+----
+old b
+`
+	// The top-level directive is reviewed before the parallel subtest runs.
+	cases := []struct {
+		name, parallel, answers string
+	}{
+		{"sequential", "", "n\ny\n"},
+		{"parallel", " parallel", "y\nn\n"},
+	}
+	if dir := os.Getenv(subprocessTestEnvVar); dir != "" {
+		*acceptResults = true
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				acceptor.in, acceptor.out = bufio.NewReader(strings.NewReader(c.answers)), ioutil.Discard
+				RunTestParallel(t, filepath.Join(dir, c.name), func(t *testing.T) func(t *testing.T, d *TestData) string {
+					return func(t *testing.T, d *TestData) string {
+						return d.Input + "\n"
+					}
+				})
 			})
-
-		default:
-			t.Fatalf("unknown directive: %s", d.Cmd)
 		}
-		return d.Expected
-	})
-}
+		return
+	}
 
-func TestDirective(t *testing.T) {
-	RunTest(t, "testdata/directive", func(t *testing.T, d *TestData) string {
-		var buf bytes.Buffer
-		fmt.Fprintf(&buf, "cmd: %s\n%d arguments\n", d.Cmd, len(d.CmdArgs))
-		for _, a := range d.CmdArgs {
-			fmt.Fprintf(&buf, "key=%#v vals=%#v\n", a.Key, a.Vals)
+	dir := t.TempDir()
+	for _, c := range cases {
+		if err := os.WriteFile(filepath.Join(dir, c.name), []byte(fmt.Sprintf(data, c.parallel)), 0644); err != nil {
+			t.Fatal(err)
 		}
-		return buf.String()
-	})
-}
-
-func TestWalk(t *testing.T) {
-	Walk(t, "testdata/walk", func(t *testing.T, path string) {
-		RunTest(t, path, func(t *testing.T, d *TestData) string {
-			return fmt.Sprintf("test name: %s\n", t.Name())
-		})
-	})
+	}
+	// The subprocess fails because of the rejected mismatches.
+	out, err := execTestSubprocess("TestAcceptSubtests", dir)
+	if err == nil {
+		t.Fatalf("expected a failure:\n%s", out)
+	}
+	for _, c := range cases {
+		if !strings.Contains(out, filepath.Join(dir, c.name)+":2: kept the expected results") {
+			t.Errorf("expected the rejected mismatch of %s to be reported:\n%s", c.name, out)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, c.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := strings.Replace(fmt.Sprintf(data, c.parallel), "old b", "b", 1)
+		if string(got) != expected {
+			t.Errorf("%s: expected the accepted results to be written:\n%s\nfound:\n%s", c.name, expected, got)
+		}
+	}
 }
 
 func TestRewrite(t *testing.T) {
@@ -394,3 +1859,531 @@ unknown command
 		return "unknown command"
 	})
 }
+
+func TestToleranceComparison(t *testing.T) {
+	for _, tc := range []struct {
+		expected, actual string
+		matches          bool
+		rewrite          string
+	}{
+		{
+			expected: "pi = 3.14159\n",
+			actual:   "pi = 3.141592653589793\n",
+			matches:  true,
+			rewrite:  "pi = 3.14159\n",
+		},
+		{
+			expected: "a = 1e9, b = -0.000001\n",
+			actual:   "a = 1000000001, b = 0\n",
+			matches:  true,
+			rewrite:  "a = 1e9, b = -0.000001\n",
+		},
+		{
+			expected: "x1 = 0.5\ny = 2\n",
+			actual:   "x1 = 0.5\ny = 2.1\n",
+			rewrite:  "x1 = 0.5\ny = 2.1\n",
+		},
+		{
+			expected: "a = 1.0000001\nb = 1\n",
+			actual:   "a = 1\nb = 2\n",
+			rewrite:  "a = 1.0000001\nb = 2\n",
+		},
+		{
+			expected: "a = 1\n",
+			actual:   "b = 1\n",
+			rewrite:  "b = 1\n",
+		},
+		{
+			expected: "1 2\n",
+			actual:   "1 2 3\n",
+			rewrite:  "1 2 3\n",
+		},
+	} {
+		cmp, err := newToleranceComparison(CmdArg{Key: "tolerance", Vals: []string{"1e-3"}}, tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := cmp.matches(tc.actual); matches != tc.matches {
+			t.Errorf("%q: expected matches(%q) = %t", tc.expected, tc.actual, tc.matches)
+		}
+		if rewrite := cmp.rewrite(tc.actual); rewrite != tc.rewrite {
+			t.Errorf("%q: expected rewrite(%q) = %q, found %q", tc.expected, tc.actual, tc.rewrite, rewrite)
+		}
+	}
+
+	for _, val := range []string{"x", "-1"} {
+		if _, err := newToleranceComparison(CmdArg{Key: "tolerance", Vals: []string{val}}, ""); err == nil {
+			t.Errorf("expected an error for tolerance=%s", val)
+		}
+	}
+}
+
+type codeError struct {
+	msg, code string
+}
+
+func (e *codeError) Error() string     { return e.msg }
+func (e *codeError) ErrorCode() string { return e.code }
+
+type joinedError []error
+
+func (e joinedError) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e joinedError) Unwrap() []error { return e }
+
+func TestRunTestErr(t *testing.T) {
+	RunTestErr(t, "testdata/errors", func(t *testing.T, d *TestData) (string, error) {
+		var fail, code, join string
+		d.MaybeScanArgs(t, "fail", &fail)
+		d.MaybeScanArgs(t, "code", &code)
+		d.MaybeScanArgs(t, "join", &join)
+		for _, arg := range d.CmdArgs {
+			if arg.Key == expectErrorArg || arg.Key == errorChainArg {
+				t.Errorf("unexpected argument %s", arg.Key)
+			}
+		}
+		switch {
+		case fail == "":
+			return d.Input, nil
+		case code != "":
+			return d.Input, fmt.Errorf("run: %w", &codeError{msg: fail, code: code})
+		case join != "":
+			return d.Input, joinedError{errors.New(fail), errors.New(join)}
+		default:
+			return d.Input, errors.New(fail)
+		}
+	})
+}
+
+func TestRunTestErrRetry(t *testing.T) {
+	for _, tc := range []struct {
+		directive string
+		// errors is the number of calls which return an error, before the
+		// calls which succeed.
+		errors int
+		failed bool
+	}{
+		// The expect-error argument is checked against the last call.
+		{directive: "run retry expect-error", errors: 1, failed: true},
+		{directive: "run retry expect-error=false", errors: 1, failed: false},
+		{directive: "run retry expect-error", errors: 1000, failed: false},
+	} {
+		t.Run(tc.directive, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test")
+			input := tc.directive + "\n----\nok\n"
+			if tc.errors > 1 {
+				input = tc.directive + "\n----\nerror: boom\n"
+			}
+			if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			calls := 0
+			rt := &recordingT{}
+			rt.Run("", func(rt testing.TB) {
+				RunTestErrWithOptionsAny(rt, path, func(t testing.TB, d *TestData) (string, error) {
+					if len(d.CmdArgs) > 0 {
+						t.Errorf("unexpected arguments: %v", d.CmdArgs)
+					}
+					if calls++; calls <= tc.errors {
+						return "", errors.New("boom")
+					}
+					return "ok", nil
+				}, RetryArg())
+			})
+			if rt.Failed() != tc.failed {
+				t.Errorf("expected failed=%t, found:\n%s", tc.failed, strings.Join(rt.logs, "\n"))
+			}
+		})
+	}
+}
+
+func TestOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte(`
+write
+a
+b
+----
+a
+b
+
+write-and-return
+a
+----
+a
+returned
+
+# The order of the lines depends on the scheduling of the goroutines.
+write-concurrently n=3 unordered
+----
+0
+1
+2
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	RunTestWithOptions(t, path, func(t *testing.T, d *TestData) string {
+		switch d.Cmd {
+		case "write":
+			for _, line := range strings.Split(d.Input, "\n") {
+				fmt.Fprintln(d.Out, line)
+			}
+			return ""
+		case "write-and-return":
+			fmt.Fprintln(d.Out, d.Input)
+			return "returned"
+		case "write-concurrently":
+			var n int
+			d.ScanArgs(t, "n", &n)
+			done := make(chan struct{})
+			for i := 0; i < n; i++ {
+				go func(i int) {
+					fmt.Fprintf(d.Out, "%d\n", i)
+					done <- struct{}{}
+				}(i)
+			}
+			for i := 0; i < n; i++ {
+				<-done
+			}
+			return ""
+		}
+		d.Fatalf(t, "unknown command %s", d.Cmd)
+		return ""
+	}, ComparisonArgs("unordered"))
+}
+
+// recordingT is a testing.TB which records the logs and failures of the
+// directives run with it, instead of failing the test.
+type recordingT struct {
+	testing.TB
+	mu       sync.Mutex
+	logs     []string
+	failed   bool
+	cleanups []func()
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Name() string { return "recording" }
+
+func (t *recordingT) Logf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.logs = append(t.logs, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.Logf(format, args...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed = true
+}
+
+func (t *recordingT) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (t *recordingT) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+func (t *recordingT) Skipped() bool { return false }
+
+func (t *recordingT) Cleanup(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cleanups = append(t.cleanups, f)
+}
+
+// Run runs f in a goroutine, as testing.T.Run does, and records a panic as a
+// failure.
+func (t *recordingT) Run(name string, f func(testing.TB)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("panic: %v", r)
+			}
+		}()
+		defer func() {
+			for i := len(t.cleanups) - 1; i >= 0; i-- {
+				t.cleanups[i]()
+			}
+			t.cleanups = nil
+		}()
+		f(t)
+	}()
+	<-done
+}
+
+func TestOutPartial(t *testing.T) {
+	for _, tc := range []struct {
+		cmd      string
+		expected string
+	}{
+		{
+			cmd:      "panic",
+			expected: "\npanic during <string>:2:\ninput\npartial output:\nwritten\n",
+		},
+		{
+			cmd:      "fatal",
+			expected: "\nfatal error during <string>:2:\ninput\npartial output:\nwritten\n",
+		},
+	} {
+		t.Run(tc.cmd, func(t *testing.T) {
+			rt := &recordingT{}
+			rt.Run(tc.cmd, func(rt testing.TB) {
+				RunTestFromStringAny(rt, "\n"+tc.cmd+"\ninput\n----\n", func(t testing.TB, d *TestData) string {
+					fmt.Fprint(d.Out, "written")
+					if d.Cmd == "panic" {
+						panic("boom")
+					}
+					t.Fatalf("boom")
+					return ""
+				})
+			})
+			if !rt.Failed() {
+				t.Fatal("expected a failure")
+			}
+			found := false
+			for _, log := range rt.logs {
+				if log == tc.expected {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected the log %q, found:\n%q", tc.expected, rt.logs)
+			}
+		})
+	}
+}
+
+func TestRunTestWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test")
+	if err := os.WriteFile(path, []byte(`echo
+id 1234
+----
+id <n>
+
+echo
+x
+----
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	echo := func(t *testing.T, d *TestData) string {
+		return d.Input
+	}
+	RunTestWithOptions(t, path, echo,
+		BeforeFile(func(t testing.TB, path string) {
+			events = append(events, "before file "+filepath.Base(path))
+		}),
+		AfterFile(func(t testing.TB, path string) {
+			events = append(events, "after file "+filepath.Base(path))
+		}),
+		BeforeDirective(func(t testing.TB, d *TestData) {
+			events = append(events, "before "+d.Pos[strings.LastIndex(d.Pos, "/")+1:])
+		}),
+		AfterDirective(func(t testing.TB, d *TestData, actual string) {
+			events = append(events, fmt.Sprintf("after %s: %q", d.Pos[strings.LastIndex(d.Pos, "/")+1:], actual))
+		}),
+		Scrubber(func(actual string) string {
+			return regexp.MustCompile(`[0-9]+`).ReplaceAllString(actual, "<n>")
+		}),
+		Rewrite(true),
+		Quiet(true),
+	)
+	expectedEvents := []string{
+		"before file test",
+		"before test:1",
+		`after test:1: "id <n>\n"`,
+		"before test:6",
+		`after test:6: "x\n"`,
+		"after file test",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected the events:\n%q\nfound:\n%q", expectedEvents, events)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "echo\nid 1234\n----\nid <n>\n\necho\nx\n----\nx\n"; string(data) != expected {
+		t.Errorf("expected the rewritten file:\n%s\nfound:\n%s", expected, data)
+	}
+}
+
+func TestWalkRunOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("echo\nx\n----\nx\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var files []string
+	record := func(prefix string) WalkOption {
+		return WalkRunOptions(BeforeFile(func(t testing.TB, path string) {
+			files = append(files, prefix+filepath.Base(path))
+		}))
+	}
+	echo := func(t *testing.T, d *TestData) string {
+		return d.Input
+	}
+	// A nested walk on the same test passes its own options.
+	WalkWithOptions(t, dir, func(t *testing.T, path string, opts []RunOption) {
+		RunTestWithOptions(t, path, echo, opts...)
+		WalkWithOptions(t, path, func(t *testing.T, path string, opts []RunOption) {
+			RunTestWithOptions(t, path, echo, opts...)
+		}, record("nested:"))
+	}, record(""))
+	if expected := []string{"a", "nested:a", "b", "nested:b"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %q, found %q", expected, files)
+	}
+
+	// The options are ignored by Walk.
+	files = nil
+	Walk(t, dir, func(t *testing.T, path string) {
+		RunTest(t, path, echo)
+	}, record(""))
+	if len(files) != 0 {
+		t.Errorf("unexpected files %q", files)
+	}
+}
+
+func TestDiffContext(t *testing.T) {
+	const input = `
+echo
+1
+2
+3
+4
+5
+6
+----
+1
+2
+3
+4
+5
+x
+`
+	for _, tc := range []struct {
+		lines    int
+		expected string
+	}{
+		{lines: 1, expected: "@@ -5,3 +5,3 @@\n 5\n-x\n+6\n \n"},
+		{lines: -1, expected: "expected:\n1\n2\n3\n4\n5\nx\n\nfound:\n1\n2\n3\n4\n5\n6\n"},
+	} {
+		rt := &recordingT{}
+		rt.Run("", func(rt testing.TB) {
+			path := filepath.Join(t.TempDir(), "test")
+			if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			RunTestWithOptionsAny(rt, path, func(t testing.TB, d *TestData) string {
+				return d.Input
+			}, DiffContext(tc.lines), Rewrite(false))
+		})
+		if len(rt.logs) == 0 || !strings.HasSuffix(rt.logs[len(rt.logs)-1], tc.expected) {
+			t.Errorf("DiffContext(%d): expected a failure ending with:\n%s\nfound:\n%q", tc.lines, tc.expected, rt.logs)
+		}
+	}
+}
+
+func TestParseRewriteFilter(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.ToSlash(filepath.Join(wd, "testdata/compare/json"))
+	for _, tc := range []struct {
+		filter   string
+		path     string
+		expected bool
+	}{
+		{filter: "1", path: "testdata/compare/json", expected: true},
+		{filter: "true", path: "testdata/compare/json", expected: true},
+		{filter: "0", path: "testdata/compare/json", expected: false},
+		{filter: "testdata/compare/*", path: "testdata/compare/json", expected: true},
+		{filter: "testdata/*", path: "testdata/compare/json", expected: false},
+		{filter: "json", path: "testdata/compare/json", expected: true},
+		{filter: "j*", path: "testdata/compare/json", expected: true},
+		{filter: abs, path: "testdata/compare/json", expected: true},
+		{filter: "re:compare/", path: "testdata/compare/json", expected: true},
+		{filter: "re:^/.*/json$", path: "testdata/compare/json", expected: true},
+		{filter: "re:^testdata", path: "testdata/compare/json", expected: false},
+	} {
+		filter, err := parseRewriteFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := filter(tc.path); match != tc.expected {
+			t.Errorf("%s: expected %t for %s, found %t", tc.filter, tc.expected, tc.path, match)
+		}
+	}
+
+	for _, filter := range []string{"re:(", "["} {
+		if _, err := parseRewriteFilter(filter); err == nil {
+			t.Errorf("expected an error for %s", filter)
+		}
+	}
+}
+
+func TestRewriteFilter(t *testing.T) {
+	defer func(filter func(string) bool) { rewriteFilter = filter }(rewriteFilter)
+	var err error
+	if rewriteFilter, err = parseRewriteFilter("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("echo\nx\n----\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	RunTest(t, filepath.Join(dir, "a"), func(t *testing.T, d *TestData) string {
+		if !d.Rewrite {
+			t.Errorf("%s: expected the file to be rewritten", d.Pos)
+		}
+		return d.Input
+	})
+	rt := &recordingT{}
+	rt.Run("", func(rt testing.TB) {
+		RunTestAny(rt, filepath.Join(dir, "b"), func(t testing.TB, d *TestData) string {
+			if d.Rewrite {
+				t.Errorf("%s: unexpected rewrite", d.Pos)
+			}
+			return d.Input
+		})
+	})
+	if !rt.Failed() {
+		t.Errorf("expected the results of b not to match")
+	}
+
+	for name, expected := range map[string]string{
+		"a": "echo\nx\n----\nx\n",
+		"b": "echo\nx\n----\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected:\n%s\nfound:\n%s", name, expected, data)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// RunTestErr is like RunTest, but the function also returns an error. A nil
+// error leaves the output of the function unchanged; otherwise the error is
+// rendered after any partial output returned along with it:
+//
+//	<partial output>
+//	error: <message>
+//
+// If an error in the chain of wrapped errors has an ErrorCode() string method,
+// as the errors of some database drivers do, the first such code is added:
+//
+//	error: <message>
+//	  code: <code>
+//
+// The following arguments are handled by RunTestErr, for any command, and are
+// not passed to the function:
+//   - expect-error (or expect-error=<bool>): the test fails if the function
+//     does not return an error (or, with expect-error=false, if it does).
+//   - error-chain: the chain of wrapped errors, as examined by errors.Is and
+//     errors.As, is added after the error, one line with the type and the
+//     message of each error.
+func RunTestErr(t *testing.T, path string, f func(t *testing.T, d *TestData) (string, error)) {
+	t.Helper()
+	RunTestErrAny(t, path, func(t testing.TB, d *TestData) (string, error) {
+		return f(t.(*testing.T), d)
+	})
+}
+
+// RunTestErrAny is like RunTestErr but works over a testing.TB.
+func RunTestErrAny(t testing.TB, path string, f func(t testing.TB, d *TestData) (string, error)) {
+	t.Helper()
+	runTestFile(t, path, errHandler(f), nil /* newHandler */, []RunOption{handleErrorArgs})
+}
+
+// RunTestErrWithOptions is like RunTestErr, with the given options.
+func RunTestErrWithOptions(
+	t *testing.T,
+	path string,
+	f func(t *testing.T, d *TestData) (string, error),
+	opts ...RunOption,
+) {
+	t.Helper()
+	RunTestErrWithOptionsAny(t, path, func(t testing.TB, d *TestData) (string, error) {
+		return f(t.(*testing.T), d)
+	}, opts...)
+}
+
+// RunTestErrWithOptionsAny is like RunTestErrWithOptions but works over a
+// testing.TB.
+func RunTestErrWithOptionsAny(
+	t testing.TB, path string, f func(t testing.TB, d *TestData) (string, error), opts ...RunOption,
+) {
+	t.Helper()
+	runTestFile(t, path, errHandler(f), nil /* newHandler */, append([]RunOption{handleErrorArgs}, opts...))
+}
+
+// RunTestErrFromString is a version of RunTestErr which takes the contents of
+// a test directly.
+func RunTestErrFromString(
+	t *testing.T, input string, f func(t *testing.T, d *TestData) (string, error),
+) {
+	t.Helper()
+	handler := errHandler(func(t testing.TB, d *TestData) (string, error) {
+		return f(t.(*testing.T), d)
+	})
+	runTestInternal(t, "<string>" /* sourceName */, strings.NewReader(input), handler,
+		*rewriteTestFiles, handleErrorArgs)
+}
+
+// handleErrorArgs is the RunOption of RunTestErr, which causes the framework
+// to handle the expect-error and error-chain arguments.
+func handleErrorArgs(o *runOptions) {
+	o.errorArgs = true
+}
+
+// errHandler adapts a function returning an error to the handlers of
+// runTestFile.
+func errHandler(
+	f func(t testing.TB, d *TestData) (string, error),
+) func(t testing.TB, d *TestData) string {
+	return func(t testing.TB, d *TestData) string {
+		t.Helper()
+		out, err := f(t, d)
+		chain := false
+		if args := d.errorArgs; args != nil {
+			args.err = err
+			chain = args.chain
+		}
+		if err == nil {
+			return out
+		}
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		return out + formatError(err, chain)
+	}
+}
+
+const (
+	// expectErrorArg and errorChainArg are the arguments handled by
+	// stripErrorArgs.
+	expectErrorArg = "expect-error"
+	errorChainArg  = "error-chain"
+)
+
+// errorArgs are the arguments of a directive run by RunTestErr.
+type errorArgs struct {
+	// check is set if the expect-error argument is present, and expect if it
+	// requires an error.
+	check, expect bool
+	// chain is set if the error-chain argument is present.
+	chain bool
+	// err is the error returned by the last call of the function.
+	err error
+}
+
+// stripErrorArgs removes the expect-error and error-chain arguments from the
+// directive. The framework calls it once per directive, so that all the calls
+// of the function see the same arguments when the directive is retried.
+func stripErrorArgs(t testing.TB, d *TestData) *errorArgs {
+	t.Helper()
+	args := &errorArgs{}
+	_, args.chain = stripArg(d, errorChainArg)
+	arg, ok := stripArg(d, expectErrorArg)
+	if !ok {
+		return args
+	}
+	args.check = true
+	switch len(arg.Vals) {
+	case 0:
+		args.expect = true
+	case 1:
+		var err error
+		if args.expect, err = strconv.ParseBool(arg.Vals[0]); err != nil {
+			d.Fatalf(t, "invalid %s argument: %v", expectErrorArg, err)
+		}
+	default:
+		d.Fatalf(t, "invalid %s argument: expected a single boolean", expectErrorArg)
+	}
+	return args
+}
+
+// checkError fails the test if the error returned by the last call of the
+// function disagrees with the expect-error argument.
+func (args *errorArgs) checkError(t testing.TB, d *TestData, actual string) {
+	t.Helper()
+	if !args.check {
+		return
+	}
+	if args.expect && args.err == nil {
+		d.Fatalf(t, "expected an error, found:\n%s", actual)
+	}
+	if !args.expect && args.err != nil {
+		d.Fatalf(t, "unexpected error: %v", args.err)
+	}
+}
+
+// formatError renders an error as described in RunTestErr.
+func formatError(err error, chain bool) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "error: %v\n", err)
+	if code, ok := errorCode(err); ok {
+		fmt.Fprintf(&buf, "  code: %s\n", code)
+	}
+	if chain {
+		writeErrorChain(&buf, err, 0 /* depth */)
+	}
+	return buf.String()
+}
+
+// errorCode returns the code of the first error in the chain of wrapped
+// errors which has one.
+func errorCode(err error) (string, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(interface{ ErrorCode() string }); ok {
+			return e.ErrorCode(), true
+		}
+	}
+	return "", false
+}
+
+// writeErrorChain writes an error and the errors it wraps, one per line. The
+// errors wrapped by an error with an Unwrap() []error method are indented
+// below it.
+func writeErrorChain(buf *strings.Builder, err error, depth int) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		fmt.Fprintf(buf, "%s%T: %v\n", strings.Repeat("  ", depth), err, err)
+		if e, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, wrapped := range e.Unwrap() {
+				writeErrorChain(buf, wrapped, depth+1)
+			}
+			return
+		}
+	}
+}
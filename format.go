@@ -0,0 +1,312 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Format writes a test file. The nodes produced by ParseFile are reproduced
+// byte for byte unless they were modified, so that parsing and formatting an
+// unmodified file yields the original file. Nodes which were modified or added
+// are written in canonical form.
+//
+// The parts of a directive are considered separately: the directive line, the
+// input, and the expected results. For example, adding an argument to a
+// directive rewrites the directive line in canonical form (joining any
+// continuation lines), but leaves the input and the expected results as they
+// were.
+//
+// In canonical form, the arguments of a directive are separated by single
+// spaces, and the double ---- separator is only used when the expected
+// results contain blank lines. A Subtest is written using its Name and
+// Parallel fields if they were modified, or if it has no Start directive; a
+// "subtest end" directive is added if End is nil, unless the subtest was
+// parsed from a file that lacked it.
+func Format(w io.Writer, f *File) error {
+	var p formatter
+	p.formatNodes(f.Nodes)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type formatter struct {
+	buf bytes.Buffer
+	// pendingBlank is set if a blank line must be written before the next
+	// node, to terminate canonical expected results. It is dropped at the end
+	// of the file.
+	pendingBlank bool
+}
+
+func (p *formatter) write(s string) {
+	if p.pendingBlank {
+		p.buf.WriteByte('\n')
+		p.pendingBlank = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *formatter) formatNodes(nodes []Node) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Blank:
+			if n.raw == "" {
+				p.write("\n")
+			} else {
+				p.write(n.raw)
+			}
+		case *Comment:
+			p.formatComment(n)
+		case *Directive:
+			p.formatDirective(n)
+		case *Subtest:
+			p.formatSubtest(n)
+		}
+	}
+}
+
+func (p *formatter) formatComment(c *Comment) {
+	if c.raw != "" && c.Text == c.origText {
+		p.write(c.raw)
+		return
+	}
+	text := strings.TrimSpace(c.Text)
+	if !strings.HasPrefix(text, "#") {
+		text = "# " + text
+	}
+	p.write(text + "\n")
+}
+
+func (p *formatter) formatDirective(d *Directive) {
+	orig := d.orig
+	if orig == nil {
+		orig = &directiveFields{}
+	}
+
+	if d.orig != nil && d.Cmd == orig.cmd && reflect.DeepEqual(d.CmdArgs, orig.cmdArgs) {
+		p.write(d.rawLine)
+	} else {
+		p.write(formatDirectiveLine(d.Cmd, d.CmdArgs) + "\n")
+	}
+	if d.Cmd == "subtest" {
+		return
+	}
+
+	if d.orig != nil && d.Input == orig.input {
+		p.write(d.rawInput)
+	} else if d.Input != "" {
+		p.write(d.Input + "\n")
+	}
+
+	if d.orig != nil && d.Expected == orig.expected {
+		p.write(d.rawExpected)
+	} else {
+		p.write(formatExpected(d.Expected))
+		p.pendingBlank = true
+	}
+}
+
+func (p *formatter) formatSubtest(s *Subtest) {
+	if s.Start != nil && (!s.parsed || (s.Name == s.origName && s.Parallel == s.origParallel)) {
+		p.formatDirective(s.Start)
+	} else {
+		args := []CmdArg{{Key: s.Name}}
+		if s.Parallel {
+			args = append(args, CmdArg{Key: "parallel"})
+		}
+		p.write(formatDirectiveLine("subtest", args) + "\n")
+	}
+	p.formatNodes(s.Nodes)
+	if s.End != nil {
+		p.formatDirective(s.End)
+	} else if !s.parsed {
+		p.write("subtest end\n")
+	}
+}
+
+// formatDirectiveLine returns the canonical directive line for the given
+// command and arguments.
+func formatDirectiveLine(cmd string, args []CmdArg) string {
+	var buf strings.Builder
+	buf.WriteString(cmd)
+	for _, arg := range args {
+		buf.WriteByte(' ')
+		buf.WriteString(formatCmdArg(arg))
+	}
+	return buf.String()
+}
+
+// formatCmdArg returns the canonical form of an argument. This is the same as
+// arg.String(), except that parentheses are used around a single value when
+// they are required for the value to be parsed back.
+func formatCmdArg(arg CmdArg) string {
+	if len(arg.Vals) == 1 {
+		if v := arg.Vals[0]; strings.ContainsAny(v, " \t") || strings.HasPrefix(v, "(") {
+			return arg.Key + "=(" + v + ")"
+		}
+	}
+	return arg.String()
+}
+
+// formatExpected returns the canonical form of the expected results of a
+// directive, starting with the ---- separator. The terminating blank line is
+// not included.
+func formatExpected(expected string) string {
+	if expected != "" && !strings.HasSuffix(expected, "\n") {
+		expected += "\n"
+	}
+	// Expected results which start with ---- would be mistaken for the double
+	// separator.
+	if hasBlankLine(expected) || strings.HasPrefix(expected, "----\n") {
+		return "----\n----\n" + expected + "----\n----\n"
+	}
+	return "----\n" + expected
+}
+
+// Canonicalize modifies a parsed file so that Format writes it in canonical
+// form, regardless of how the nodes were originally written. Canonicalizing a
+// file does not change its meaning: it is parsed back into the same
+// directives, with the same inputs and expected results. In canonical form:
+//
+//   - directive lines are written as described for Format, except for
+//     directive lines wrapped using \, which are only stripped of trailing
+//     whitespace;
+//   - comments are stripped of surrounding whitespace;
+//   - runs of blank lines are collapsed into one blank line, and blank lines
+//     at the beginning and at the end of the file, or following expected
+//     results (which are already terminated by a blank line), are removed;
+//   - the double ---- separator is only used when it is required;
+//   - lines are terminated by \n, including the last line of the file.
+//
+// The input and the expected results are otherwise left as they are, since
+// all their characters are significant. The file should have been parsed
+// without errors.
+func Canonicalize(f *File) {
+	f.Nodes = canonicalizeNodes(f.Nodes, true /* top */)
+	if n := len(f.Nodes); n > 0 {
+		if _, ok := f.Nodes[n-1].(*Blank); ok {
+			f.Nodes = f.Nodes[:n-1]
+		}
+	}
+	if len(f.Nodes) > 0 {
+		canonicalizeEnd(f.Nodes[len(f.Nodes)-1])
+	}
+}
+
+func canonicalizeNodes(nodes []Node, top bool) []Node {
+	var res []Node
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Blank:
+			if (top && len(res) == 0) || (len(res) > 0 && endsWithBlank(res[len(res)-1])) {
+				continue
+			}
+			n.raw = "\n"
+		case *Comment:
+			if n.raw != "" && n.Text == n.origText {
+				n.raw = n.Text + "\n"
+			}
+		case *Directive:
+			canonicalizeDirective(n)
+		case *Subtest:
+			if n.Start != nil {
+				canonicalizeDirective(n.Start)
+			}
+			n.Nodes = canonicalizeNodes(n.Nodes, false /* top */)
+			if n.End != nil {
+				canonicalizeDirective(n.End)
+			}
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// canonicalizeDirective replaces the raw text of the unmodified parts of a
+// parsed directive with their canonical form.
+func canonicalizeDirective(d *Directive) {
+	if d.orig == nil {
+		// The directive is written in canonical form anyway.
+		return
+	}
+	if d.Cmd != "" && d.Cmd == d.orig.cmd && reflect.DeepEqual(d.CmdArgs, d.orig.cmdArgs) {
+		lines := strings.SplitAfter(strings.TrimSuffix(d.rawLine, "\n"), "\n")
+		if len(lines) == 1 {
+			d.rawLine = formatDirectiveLine(d.Cmd, d.CmdArgs) + "\n"
+		} else {
+			for i := range lines {
+				lines[i] = strings.TrimRight(lines[i], " \t\r\n") + "\n"
+			}
+			d.rawLine = strings.Join(lines, "")
+		}
+	}
+	if d.Input == d.orig.input && d.rawInput != "" {
+		d.rawInput = strings.ReplaceAll(d.rawInput, "\r\n", "\n")
+		if !strings.HasSuffix(d.rawInput, "\n") {
+			d.rawInput += "\n"
+		}
+	}
+	if d.Expected == d.orig.expected && d.HasSeparator() {
+		d.rawExpected = formatExpected(d.Expected) + "\n"
+	}
+}
+
+// endsWithBlank returns true if the given node, once canonicalized, is
+// written with a trailing blank line.
+func endsWithBlank(n Node) bool {
+	switch n := n.(type) {
+	case *Blank:
+		return true
+	case *Directive:
+		if n.Cmd == "subtest" {
+			return false
+		}
+		return n.orig == nil || n.Expected != n.orig.expected || n.HasSeparator()
+	}
+	return false
+}
+
+// canonicalizeEnd removes the blank lines at the end of the last node of a
+// canonicalized file.
+func canonicalizeEnd(n Node) {
+	switch n := n.(type) {
+	case *Subtest:
+		if n.End != nil {
+			canonicalizeEnd(n.End)
+		} else if len(n.Nodes) > 0 {
+			canonicalizeEnd(n.Nodes[len(n.Nodes)-1])
+		}
+	case *Directive:
+		if n.orig == nil {
+			return
+		}
+		switch {
+		case n.Expected == n.orig.expected && n.HasSeparator():
+			n.rawExpected = strings.TrimRight(n.rawExpected, "\n") + "\n"
+		case !n.HasSeparator() && n.Input == n.orig.input && n.rawInput != "":
+			// The input is trimmed, so trailing whitespace is not significant.
+			n.rawInput = strings.TrimRight(n.rawInput, " \t\r\n")
+			if n.rawInput != "" {
+				n.rawInput += "\n"
+			}
+		}
+		if !strings.HasSuffix(n.rawLine, "\n") {
+			n.rawLine += "\n"
+		}
+	}
+}
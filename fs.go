@@ -0,0 +1,124 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// RunTestFS is like RunTest, but reads the test file from the given file
+// system, for example an embed.FS. This allows running tests on machines that
+// don't have a copy of the source tree:
+//
+//	//go:embed testdata
+//	var testdata embed.FS
+//
+//	func TestFoo(t *testing.T) {
+//	  datadriven.RunTestFS(t, testdata, "testdata/foo", func(t *testing.T, d *datadriven.TestData) string {
+//	    // ...
+//	  })
+//	}
+//
+// The path is slash-separated, as required by fs.FS. A file system is
+// generally read-only, so the test fails if -rewrite is used, unless the file
+// system was wrapped with WithRewriteDir.
+func RunTestFS(
+	t *testing.T, fsys fs.FS, path string, f func(t *testing.T, d *TestData) string,
+) {
+	t.Helper()
+	RunTestFSAny(t, fsys, path, func(t testing.TB, d *TestData) string {
+		return f(t.(*testing.T), d)
+	})
+}
+
+// RunTestFSAny is like RunTestFS but works over a testing.TB.
+func RunTestFSAny(
+	t testing.TB, fsys fs.FS, path string, f func(t testing.TB, d *TestData) string,
+) {
+	t.Helper()
+
+	o := newRunOptions(nil /* opts */)
+	finfo, err := fs.Stat(fsys, path)
+	if err != nil {
+		t.Fatal(err)
+	} else if finfo.IsDir() {
+		t.Fatalf("%s is a directory, not a file; consider using datadriven.WalkFS", path)
+	}
+	var writeBack func(rewriteData []byte)
+	if o.rewriting(path) {
+		rfs, ok := fsys.(*rewriteDirFS)
+		if !ok {
+			t.Fatalf("cannot rewrite %s: the file system is read-only; "+
+				"use datadriven.WithRewriteDir to specify where to write the test files", path)
+		}
+		diskPath := filepath.Join(rfs.dir, filepath.FromSlash(path))
+		writeBack = func(rewriteData []byte) {
+			if err := os.MkdirAll(filepath.Dir(diskPath), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(diskPath, rewriteData, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestDataReader(t, path, bytes.NewReader(data), o.rewriting(path))
+	r.opts = o
+	r.accept = o.accepting()
+	runTest(t, r, f, writeBack)
+}
+
+// WalkFS is like Walk, but walks the given file system, for example an
+// embed.FS. The paths passed to f are slash-separated paths within the file
+// system, which can be passed to RunTestFS.
+func WalkFS(
+	t *testing.T, fsys fs.FS, path string, f func(t *testing.T, path string), opts ...WalkOption,
+) {
+	t.Helper()
+	WalkFSAny(t, fsys, path, func(t testing.TB, path string) {
+		f(t.(*testing.T), path)
+	}, opts...)
+}
+
+// WalkFSAny is like WalkFS but works over a testing.TB.
+func WalkFSAny(
+	t testing.TB, fsys fs.FS, path string, f func(t testing.TB, path string), opts ...WalkOption,
+) {
+	t.Helper()
+	walk(t, ioFS{fsys: fsys}, path, f, opts)
+}
+
+// WithRewriteDir wraps a file system so that RunTestFS can rewrite the test
+// files read from it when -rewrite is used. The rewritten files are written
+// under the given directory on disk, which mirrors the paths of the file
+// system. For example, with an embed.FS declared in the package being tested,
+// the directory would be that of the package (usually "."), so that the
+// embedded files are rewritten in place.
+func WithRewriteDir(fsys fs.FS, dir string) fs.FS {
+	return &rewriteDirFS{FS: fsys, dir: dir}
+}
+
+type rewriteDirFS struct {
+	fs.FS
+	dir string
+}
@@ -16,17 +16,24 @@ package datadriven
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 )
 
 type lineScanner struct {
 	*bufio.Scanner
 	line int
+	// offset is the byte offset of the end of the last line scanned.
+	offset int
+	// record, if set, receives a copy of every line that is scanned.
+	record *bytes.Buffer
 }
 
 func newLineScanner(r io.Reader) *lineScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanRawLines)
 	return &lineScanner{
-		Scanner: bufio.NewScanner(r),
+		Scanner: scanner,
 		line:    0,
 	}
 }
@@ -35,6 +42,44 @@ func (l *lineScanner) Scan() bool {
 	ok := l.Scanner.Scan()
 	if ok {
 		l.line++
+		l.offset += len(l.Scanner.Bytes())
+		if l.record != nil {
+			l.record.WriteString(l.Text())
+			l.record.WriteByte('\n')
+		}
 	}
 	return ok
 }
+
+// Text returns the last line scanned, without the line terminator.
+func (l *lineScanner) Text() string {
+	return string(dropEOL(l.Scanner.Bytes()))
+}
+
+// RawText returns the last line scanned, including the line terminator (if
+// any).
+func (l *lineScanner) RawText() string {
+	return l.Scanner.Text()
+}
+
+// scanRawLines is like bufio.ScanLines, but keeps the line terminators, so that
+// the files can be reproduced exactly.
+func scanRawLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	// Request more data.
+	return 0, nil, nil
+}
+
+// dropEOL removes the line terminator, either \n or \r\n, from a line.
+func dropEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
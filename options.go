@@ -0,0 +1,254 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"fmt"
+	"testing"
+)
+
+// RunTestWithOptions is like RunTest, with the given options.
+func RunTestWithOptions(
+	t *testing.T, path string, f func(t *testing.T, d *TestData) string, opts ...RunOption,
+) {
+	t.Helper()
+	RunTestWithOptionsAny(t, path, func(t testing.TB, d *TestData) string {
+		return f(t.(*testing.T), d)
+	}, opts...)
+}
+
+// RunTestWithOptionsAny is like RunTestWithOptions but works over a
+// testing.TB.
+func RunTestWithOptionsAny(
+	t testing.TB, path string, f func(t testing.TB, d *TestData) string, opts ...RunOption,
+) {
+	t.Helper()
+	runTestFile(t, path, f, nil /* newHandler */, opts)
+}
+
+// RunOption is an option that can be passed to RunTestWithOptions, or to the
+// function of WalkWithOptions using WalkRunOptions.
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	beforeFile      []func(t testing.TB, path string)
+	afterFile       []func(t testing.TB, path string)
+	beforeDirective []func(t testing.TB, d *TestData)
+	afterDirective  []func(t testing.TB, d *TestData, actual string)
+
+	// rewrite and quiet, if set, override the -rewrite and -datadriven-quiet
+	// flags.
+	rewrite *bool
+	quiet   *bool
+	// diffContext, if set, is the number of lines of context of the diffs.
+	diffContext *int
+	scrubbers   []func(actual string) string
+	// comparisonArgs are the comparison arguments handled by the framework.
+	comparisonArgs map[string]bool
+	// retryArg is set if the retry argument is handled by the framework.
+	retryArg bool
+
+	// errorArgs is set by RunTestErr; see handleErrorArgs.
+	errorArgs bool
+}
+
+// BeforeFile registers a function which is called before running each test
+// file, for example to set up the state shared by its directives.
+func BeforeFile(f func(t testing.TB, path string)) RunOption {
+	return func(o *runOptions) {
+		o.beforeFile = append(o.beforeFile, f)
+	}
+}
+
+// AfterFile registers a function which is called after running each test
+// file, even if it failed. If the file contains parallel subtests, the
+// function is called once they have finished.
+func AfterFile(f func(t testing.TB, path string)) RunOption {
+	return func(o *runOptions) {
+		o.afterFile = append(o.afterFile, f)
+	}
+}
+
+// BeforeDirective registers a function which is called before running each
+// directive, other than the subtest directives. The arguments handled by the
+// framework have been removed from the TestData.
+func BeforeDirective(f func(t testing.TB, d *TestData)) RunOption {
+	return func(o *runOptions) {
+		o.beforeDirective = append(o.beforeDirective, f)
+	}
+}
+
+// AfterDirective registers a function which is called after running each
+// directive, even if it failed, with the actual results of the directive
+// (empty if the test function did not return).
+func AfterDirective(f func(t testing.TB, d *TestData, actual string)) RunOption {
+	return func(o *runOptions) {
+		o.afterDirective = append(o.afterDirective, f)
+	}
+}
+
+// Rewrite overrides the -rewrite flag and the DATADRIVEN_REWRITE environment
+// variable: the test files are rewritten with the actual results if rewrite is
+// true, and never rewritten (nor reviewed with -datadriven-accept) if it is
+// false.
+func Rewrite(rewrite bool) RunOption {
+	return func(o *runOptions) {
+		o.rewrite = &rewrite
+	}
+}
+
+// Quiet overrides the -datadriven-quiet flag and the DATADRIVEN_QUIET_LOG
+// environment variable: in verbose mode, the directives and their results are
+// only logged if quiet is false.
+func Quiet(quiet bool) RunOption {
+	return func(o *runOptions) {
+		o.quiet = &quiet
+	}
+}
+
+// DiffContext sets the number of lines of context shown around the
+// differences when the actual results don't match expected results of more
+// than 5 lines; the default is 5. If lines is negative, the expected and
+// actual results are shown in full instead of a diff.
+func DiffContext(lines int) RunOption {
+	return func(o *runOptions) {
+		o.diffContext = &lines
+	}
+}
+
+// Scrubber registers a function which transforms the actual results before
+// they are compared with the expected results, for example to replace the
+// timestamps or the random identifiers they contain. The scrubbers are applied
+// in the order in which they are registered.
+func Scrubber(f func(actual string) string) RunOption {
+	return func(o *runOptions) {
+		o.scrubbers = append(o.scrubbers, f)
+	}
+}
+
+// ComparisonArgs enables the given comparison arguments, which are then
+// handled by the framework instead of being passed to the test function (see
+// RunTest): json, patterns, tolerance and unordered. With no arguments, all of
+// them are enabled. They are not enabled by default, so that they don't
+// conflict with the arguments of existing test suites.
+func ComparisonArgs(args ...string) RunOption {
+	if len(args) == 0 {
+		for arg := range comparisonArgs {
+			args = append(args, arg)
+		}
+	}
+	for _, arg := range args {
+		if _, ok := comparisonArgs[arg]; !ok {
+			panic(fmt.Sprintf("unknown comparison argument %q", arg))
+		}
+	}
+	return func(o *runOptions) {
+		if o.comparisonArgs == nil {
+			o.comparisonArgs = make(map[string]bool)
+		}
+		for _, arg := range args {
+			o.comparisonArgs[arg] = true
+		}
+	}
+}
+
+// RetryArg enables the retry argument, which is then handled by the framework
+// instead of being passed to the test function (see RunTest). It is not
+// enabled by default, so that it doesn't conflict with the arguments of
+// existing test suites.
+func RetryArg() RunOption {
+	return func(o *runOptions) {
+		o.retryArg = true
+	}
+}
+
+// newRunOptions returns the options for running a test file.
+func newRunOptions(opts []RunOption) *runOptions {
+	o := &runOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// The methods of runOptions can be called on a nil *runOptions, which
+// selects the defaults.
+
+// rewriting returns true if the test file at path is to be rewritten.
+func (o *runOptions) rewriting(path string) bool {
+	if o != nil && o.rewrite != nil {
+		return *o.rewrite
+	}
+	return rewriting(path)
+}
+
+// accepting returns true if the mismatches are to be reviewed.
+func (o *runOptions) accepting() bool {
+	if o != nil && o.rewrite != nil {
+		return false
+	}
+	return *acceptResults
+}
+
+// rewriteResults returns the value of TestData.Rewrite for the test file at
+// path. The tests run from a string, which have no file and no options, are
+// only rewritten with -rewrite.
+func (o *runOptions) rewriteResults(path string) bool {
+	if o == nil {
+		return *rewriteTestFiles
+	}
+	if o.rewrite != nil {
+		return *o.rewrite
+	}
+	return rewriteFile(path)
+}
+
+// verbose returns true if the directives and their results are to be logged.
+func (o *runOptions) verbose() bool {
+	if o != nil && o.quiet != nil {
+		return testing.Verbose() && !*o.quiet
+	}
+	return Verbose()
+}
+
+func (o *runOptions) handlesErrorArgs() bool {
+	return o != nil && o.errorArgs
+}
+
+func (o *runOptions) handlesRetryArg() bool {
+	return o != nil && o.retryArg
+}
+
+// comparisonArg returns true if the given comparison argument is enabled.
+func (o *runOptions) comparisonArg(arg string) bool {
+	return o != nil && o.comparisonArgs[arg]
+}
+
+func (o *runOptions) diffLines() int {
+	if o != nil && o.diffContext != nil {
+		return *o.diffContext
+	}
+	return 5
+}
+
+func (o *runOptions) scrub(actual string) string {
+	if o == nil {
+		return actual
+	}
+	for _, f := range o.scrubbers {
+		actual = f(actual)
+	}
+	return actual
+}
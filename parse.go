@@ -0,0 +1,556 @@
+// Copyright 2026 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// File is a parsed datadriven test file; see ParseFile.
+type File struct {
+	// Nodes are the top-level nodes of the file, in order.
+	Nodes []Node
+}
+
+// Node is an element of a File: a *Directive, *Comment, *Blank or *Subtest.
+type Node interface {
+	// Span returns the location of the node in the file.
+	Span() Span
+	node()
+}
+
+// Span is the location of a Node in a file.
+type Span struct {
+	// StartLine and EndLine are the numbers of the first and last line of the
+	// node, starting at 1.
+	StartLine, EndLine int
+	// StartOffset and EndOffset are the byte offsets of the beginning of the
+	// first line and of the end of the last line (after its terminator).
+	StartOffset, EndOffset int
+}
+
+// Directive is a test case: a directive line, the input to the command, and
+// the expected results. See RunTest for the syntax.
+//
+// The subtest markers ("subtest <name>" and "subtest end") are also
+// represented as directives, without input or expected results.
+type Directive struct {
+	// Cmd and CmdArgs are the command and the arguments parsed from the
+	// directive line; see ParseLine. Cmd is empty if the line could not be
+	// parsed.
+	Cmd     string
+	CmdArgs []CmdArg
+	// Input is the text between the directive line and the ---- separator,
+	// as in TestData.
+	Input string
+	// Expected is the text after the ---- separator, as in TestData.
+	Expected string
+
+	span Span
+	// rawLine is the text of the directive line, including any continuation
+	// lines.
+	rawLine string
+	// rawInput is the text of the input lines.
+	rawInput string
+	// rawExpected is the text from the ---- separator up to the end of the
+	// expected results, including the blank line that terminates them.
+	rawExpected string
+	// orig is a copy of the fields, taken when the directive was parsed. It
+	// is nil if the directive was not produced by ParseFile.
+	orig *directiveFields
+}
+
+// directiveFields contains the fields of a Directive which can be edited.
+type directiveFields struct {
+	cmd      string
+	cmdArgs  []CmdArg
+	input    string
+	expected string
+}
+
+// Comment is a line starting with #.
+type Comment struct {
+	// Text is the text of the line, without surrounding whitespace.
+	Text string
+
+	span Span
+	raw  string
+	// origText is the value of Text when the comment was parsed.
+	origText string
+}
+
+// Blank is a sequence of blank lines.
+type Blank struct {
+	span Span
+	raw  string
+}
+
+// Subtest is a group of directives delimited by "subtest <name>" and
+// "subtest end" directives. Subtests are run as Go subtests.
+type Subtest struct {
+	// Name is the full name of the subtest, which includes the name of the
+	// enclosing subtests as a prefix.
+	Name string
+	// Parallel is set if the subtest was declared with "subtest <name>
+	// parallel"; see RunTestParallel.
+	Parallel bool
+	// Start is the opening "subtest" directive.
+	Start *Directive
+	// Nodes are the nodes between the opening and closing directives.
+	Nodes []Node
+	// End is the closing "subtest end" directive. It is nil if the end of
+	// the file was reached first.
+	End *Directive
+
+	// parsed is set if the subtest was produced by ParseFile, in which case
+	// origName and origParallel are the values of Name and Parallel at that
+	// time.
+	parsed       bool
+	origName     string
+	origParallel bool
+}
+
+func (*Directive) node() {}
+func (*Comment) node()   {}
+func (*Blank) node()     {}
+func (*Subtest) node()   {}
+
+// Span implements the Node interface.
+func (d *Directive) Span() Span { return d.span }
+
+// Span implements the Node interface.
+func (c *Comment) Span() Span { return c.span }
+
+// Span implements the Node interface.
+func (b *Blank) Span() Span { return b.span }
+
+// Span implements the Node interface. A subtest without a Start directive,
+// built programmatically, starts with its first node.
+func (s *Subtest) Span() Span {
+	var span Span
+	switch {
+	case s.Start != nil:
+		span = s.Start.span
+	case len(s.Nodes) > 0:
+		span = s.Nodes[0].Span()
+	case s.End != nil:
+		return s.End.span
+	default:
+		return span
+	}
+	var end Span
+	switch {
+	case s.End != nil:
+		end = s.End.span
+	case len(s.Nodes) > 0:
+		end = s.Nodes[len(s.Nodes)-1].Span()
+	default:
+		return span
+	}
+	span.EndLine, span.EndOffset = end.EndLine, end.EndOffset
+	return span
+}
+
+// HasSeparator returns false if the directive is not followed by a ----
+// separator, in which case the input extends to the end of the file. This is
+// always the case for the subtest markers.
+func (d *Directive) HasSeparator() bool {
+	return d.rawExpected != ""
+}
+
+// Line returns the directive line in canonical form, as written by Format: the
+// command and the arguments on a single line, with the arguments separated by
+// single spaces.
+func (d *Directive) Line() string {
+	return formatDirectiveLine(d.Cmd, d.CmdArgs)
+}
+
+// snapshot records the current fields of the directive, so that Format can
+// detect modifications.
+func (d *Directive) snapshot() {
+	orig := &directiveFields{cmd: d.Cmd, input: d.Input, expected: d.Expected}
+	for _, arg := range d.CmdArgs {
+		arg.Vals = append([]string(nil), arg.Vals...)
+		orig.cmdArgs = append(orig.cmdArgs, arg)
+	}
+	d.orig = orig
+}
+
+// Lines returns the number of blank lines.
+func (b *Blank) Lines() int {
+	return b.span.EndLine - b.span.StartLine + 1
+}
+
+// Inspect traverses the nodes of the file in order, calling fn for each node.
+// For a *Subtest, fn is called for the subtest itself, then for its Start
+// directive, its nodes and its End directive (those which are not nil). If fn
+// returns false for a subtest, the contents of the subtest are skipped.
+func (f *File) Inspect(fn func(n Node) bool) {
+	inspectNodes(f.Nodes, fn)
+}
+
+func inspectNodes(nodes []Node, fn func(n Node) bool) {
+	for _, n := range nodes {
+		if !fn(n) {
+			continue
+		}
+		if s, ok := n.(*Subtest); ok {
+			if s.Start != nil {
+				fn(s.Start)
+			}
+			inspectNodes(s.Nodes, fn)
+			if s.End != nil {
+				fn(s.End)
+			}
+		}
+	}
+}
+
+// ParseError is a syntax error in a test file.
+type ParseError struct {
+	// Line is the number of the line where the error was found, starting at
+	// 1.
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseErrors is the list of syntax errors returned by ParseFile, sorted by
+// line.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+	}
+}
+
+// ParseFile parses a datadriven test file, using the same grammar as RunTest,
+// without running anything. The returned File contains the directives,
+// comments, blank lines and subtests of the file, along with their location.
+//
+// If the file contains syntax errors, ParseFile returns a partial File along
+// with a ParseErrors which lists all the errors. Other errors, such as errors
+// reading from r, are returned as-is.
+func ParseFile(r io.Reader) (*File, error) {
+	p := newItemReader(newLineScanner(r))
+	f := &File{}
+	var errs ParseErrors
+
+	// subtests is the stack of the subtests that are currently open.
+	var subtests []*Subtest
+	add := func(n Node) {
+		nodes := &f.Nodes
+		if len(subtests) > 0 {
+			nodes = &subtests[len(subtests)-1].Nodes
+		}
+		if b, ok := n.(*Blank); ok && len(*nodes) > 0 {
+			// Merge consecutive blank lines.
+			if prev, ok := (*nodes)[len(*nodes)-1].(*Blank); ok {
+				prev.span.EndLine, prev.span.EndOffset = b.span.EndLine, b.span.EndOffset
+				prev.raw += b.raw
+				return
+			}
+		}
+		*nodes = append(*nodes, n)
+	}
+	addErr := func(line int, format string, args ...interface{}) {
+		errs = append(errs, &ParseError{Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	for {
+		n, err := p.Next()
+		if err != nil {
+			perr, ok := err.(*ParseError)
+			if !ok {
+				return nil, err
+			}
+			errs = append(errs, perr)
+		}
+		if n == nil {
+			break
+		}
+		d, ok := n.(*Directive)
+		if !ok || d.Cmd != "subtest" {
+			add(n)
+			continue
+		}
+
+		line := d.span.StartLine
+		if len(d.CmdArgs) > 0 && d.CmdArgs[0].Key == "end" {
+			switch {
+			case len(d.CmdArgs) > 2:
+				addErr(line, "invalid syntax for subtest end")
+				add(d)
+			case len(subtests) == 0:
+				addErr(line, "subtest end without corresponding start")
+				add(d)
+			default:
+				s := subtests[len(subtests)-1]
+				if len(d.CmdArgs) == 2 && d.CmdArgs[1].Key != s.Name {
+					addErr(line, "mismatched subtest end directive: expected %q, got %q",
+						s.Name, d.CmdArgs[1].Key)
+				}
+				s.End = d
+				subtests = subtests[:len(subtests)-1]
+			}
+			continue
+		}
+
+		s := &Subtest{Start: d}
+		switch {
+		case len(d.CmdArgs) == 1:
+		case len(d.CmdArgs) == 2 && d.CmdArgs[1].String() == "parallel":
+			s.Parallel = true
+		default:
+			addErr(line, "invalid syntax for subtest")
+			add(d)
+			continue
+		}
+		s.Name = d.CmdArgs[0].Key
+		s.parsed, s.origName, s.origParallel = true, s.Name, s.Parallel
+		if len(subtests) > 0 {
+			if prefix := subtests[len(subtests)-1].Name + "/"; !strings.HasPrefix(s.Name, prefix) {
+				addErr(line, "name of nested subtest must begin with %q", prefix)
+			}
+		}
+		add(s)
+		subtests = append(subtests, s)
+	}
+
+	for _, s := range subtests {
+		addErr(s.Start.span.StartLine, "EOF encountered without subtest end directive")
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return f, errs
+	}
+	return f, nil
+}
+
+// LintFile returns the problems found in a test file, sorted by line: the
+// syntax errors reported by ParseFile, as well as the directives lacking the
+// ---- separator, whose input extends to the end of the file. Errors reading
+// from r are returned as-is.
+func LintFile(r io.Reader) (ParseErrors, error) {
+	f, err := ParseFile(r)
+	problems, ok := err.(ParseErrors)
+	if !ok && err != nil {
+		return nil, err
+	}
+	f.Inspect(func(n Node) bool {
+		d, ok := n.(*Directive)
+		if ok && d.Cmd != "" && d.Cmd != "subtest" && !d.HasSeparator() {
+			problems = append(problems, &ParseError{
+				Line: d.span.StartLine,
+				Msg: fmt.Sprintf("missing ---- separator after %q directive; "+
+					"its input extends to the end of the file", d.Cmd),
+			})
+		}
+		return true
+	})
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems, nil
+}
+
+// itemReader reads a test file one item at a time: a blank line, a comment,
+// or a directive along with its input and expected results. It knows nothing
+// about subtests. It is shared by ParseFile and the test runner, so that both
+// use the same grammar.
+type itemReader struct {
+	scanner *lineScanner
+	// unread is set if the last line of the scanner was read ahead and must
+	// be returned again by the next call to scan.
+	unread bool
+}
+
+func newItemReader(scanner *lineScanner) *itemReader {
+	return &itemReader{scanner: scanner}
+}
+
+func (p *itemReader) scan() bool {
+	if p.unread {
+		p.unread = false
+		return true
+	}
+	return p.scanner.Scan()
+}
+
+// Next returns the next item, as a *Blank, *Comment or *Directive, or nil at
+// the end of the file. A *ParseError is returned along with the item if it
+// contains a syntax error; the item is still complete, so that parsing can
+// continue. Any other error is an error from the underlying reader.
+func (p *itemReader) Next() (Node, error) {
+	if !p.scan() {
+		return nil, p.scanner.Err()
+	}
+	startLine := p.scanner.line
+	startOffset := p.scanner.offset - len(p.scanner.RawText())
+	// spanTo returns the span of the item, up to the last line consumed.
+	spanTo := func() Span {
+		span := Span{
+			StartLine:   startLine,
+			EndLine:     p.scanner.line,
+			StartOffset: startOffset,
+			EndOffset:   p.scanner.offset,
+		}
+		if p.unread {
+			span.EndLine--
+			span.EndOffset -= len(p.scanner.RawText())
+		}
+		return span
+	}
+	raw := p.scanner.RawText()
+	line := strings.TrimSpace(p.scanner.Text())
+	if strings.HasPrefix(line, "#") {
+		return &Comment{Text: line, span: spanTo(), raw: raw, origText: line}, nil
+	}
+
+	// Support wrapping directive lines using \, for example:
+	//   build-scalar \
+	//   vars(int)
+	for strings.HasSuffix(line, `\`) && p.scan() {
+		raw += p.scanner.RawText()
+		line = strings.TrimSuffix(line, `\`) + " " + strings.TrimSpace(p.scanner.Text())
+	}
+
+	cmd, args, parseErr := ParseLine(line)
+	if parseErr == nil && cmd == "" {
+		// Nothing but whitespace (and line continuations).
+		return &Blank{span: spanTo(), raw: raw}, nil
+	}
+	d := &Directive{Cmd: cmd, CmdArgs: args, rawLine: raw}
+	var err error
+	if parseErr != nil {
+		// Continue reading the input and expected results, as if this was a
+		// valid directive, so that the rest of the file can be parsed.
+		err = &ParseError{Line: startLine, Msg: parseErr.Error()}
+	}
+	if cmd == "subtest" {
+		// Subtest directives do not have an input and expected output.
+		d.span = spanTo()
+		d.snapshot()
+		return d, nil
+	}
+
+	var input bytes.Buffer
+	var separator bool
+	for p.scan() {
+		if p.scanner.Text() == "----" {
+			separator = true
+			break
+		}
+		d.rawInput += p.scanner.RawText()
+		fmt.Fprintln(&input, p.scanner.Text())
+	}
+	d.Input = strings.TrimSpace(input.String())
+
+	if separator {
+		if expErr := p.readExpected(d); err == nil {
+			err = expErr
+		}
+	}
+	d.span = spanTo()
+	d.snapshot()
+	return d, err
+}
+
+// readExpected reads the expected results of a directive, after the ----
+// separator has been read.
+func (p *itemReader) readExpected(d *Directive) error {
+	var buf bytes.Buffer
+	var err error
+	raw := p.scanner.RawText()
+	var line string
+	var allowBlankLines bool
+
+	if p.scan() {
+		raw += p.scanner.RawText()
+		line = p.scanner.Text()
+		if line == "----" {
+			allowBlankLines = true
+		}
+	}
+
+	if allowBlankLines {
+		// Look for two successive lines of "----" before terminating.
+		for p.scan() {
+			raw += p.scanner.RawText()
+			line = p.scanner.Text()
+
+			if line == "----" {
+				if p.scan() {
+					raw += p.scanner.RawText()
+					line2 := p.scanner.Text()
+					if line2 == "----" {
+						// Read the following blank line (if we don't do this, we will emit
+						// an extra blank line when rewriting).
+						if p.scan() {
+							if p.scanner.Text() != "" {
+								err = &ParseError{
+									Line: p.scanner.line,
+									Msg:  "non-blank line after end of double ---- separator section",
+								}
+								// Process this line again as the start of the next item.
+								p.unread = true
+							} else {
+								raw += p.scanner.RawText()
+							}
+						}
+						break
+					}
+
+					fmt.Fprintln(&buf, line)
+					fmt.Fprintln(&buf, line2)
+					continue
+				}
+			}
+
+			fmt.Fprintln(&buf, line)
+		}
+	} else {
+		// Terminate on first blank line.
+		for {
+			if strings.TrimSpace(line) == "" {
+				break
+			}
+
+			fmt.Fprintln(&buf, line)
+
+			if !p.scan() {
+				break
+			}
+
+			raw += p.scanner.RawText()
+			line = p.scanner.Text()
+		}
+	}
+
+	d.Expected = buf.String()
+	d.rawExpected = raw
+	return err
+}
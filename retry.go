@@ -0,0 +1,259 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Retry is used for tests that depend on background goroutines to finish work.
+// It takes a function that produces the output of the testcase and calls it
+// repeatedly until it matches the expected output (for at most 1 second).
+//
+// Returns the last value returned by f (which can be directly returned from the
+// function passed to RunTest).
+//
+// If --rewrite is used, just sleeps for 100ms.
+func (td *TestData) Retry(tb testing.TB, f func() string) string {
+	tb.Helper()
+	return td.RetryFor(tb, time.Second, f)
+}
+
+// RetryFor is like Retry but with a custom timeout.
+func (td *TestData) RetryFor(tb testing.TB, d time.Duration, f func() string) string {
+	tb.Helper()
+	return td.RetryWith(tb, RetryOptions{Timeout: d}, f)
+}
+
+// RetryOptions configures RetryWith. The zero value of each field selects the
+// behavior of Retry.
+type RetryOptions struct {
+	// Timeout bounds the time spent retrying: f is not called again once the
+	// total time spent sleeping between the calls reaches Timeout. The
+	// default is 1 second. In rewrite mode, f is called only once, after
+	// sleeping for a tenth of the timeout.
+	Timeout time.Duration
+	// MaxAttempts bounds the number of times f is called again after the
+	// first call. The default is 100.
+	MaxAttempts int
+	// Stable is the number of consecutive calls of f which must produce the
+	// expected output. The default is 3.
+	Stable int
+	// Backoff is the time to sleep before calling f again the first time. The
+	// default is Timeout/MaxAttempts.
+	Backoff time.Duration
+	// BackoffMultiplier multiplies the time to sleep after each call. The
+	// default is 1, i.e. a constant backoff.
+	BackoffMultiplier float64
+	// MaxBackoff, if set, bounds the time to sleep between calls.
+	MaxBackoff time.Duration
+	// Compare returns true if the output of f matches the expected output.
+	// The default compares the outputs without surrounding whitespace.
+	Compare func(expected, actual string) bool
+}
+
+// RetryWith is like Retry, with the given options.
+func (td *TestData) RetryWith(tb testing.TB, opts RetryOptions, f func() string) string {
+	tb.Helper()
+	return td.RetryWithContext(context.Background(), tb, opts, f)
+}
+
+// RetryWithContext is like RetryWith, but stops retrying when the context is
+// canceled; the last output of f is then returned.
+func (td *TestData) RetryWithContext(
+	ctx context.Context, tb testing.TB, opts RetryOptions, f func() string,
+) string {
+	tb.Helper()
+	opts.setDefaults()
+	if td.Rewrite {
+		// For rewrite mode, we have nothing to compare the output to. Just sleep a
+		// reasonable amount, under the assumption that --rewrite won't be used
+		// under stress or a loaded system.
+		_ = td.clock().Sleep(ctx, opts.Timeout/10)
+		return f()
+	}
+	runtime.Gosched()
+	// We are going to evaluate f until it produces the correct answer
+	// opts.Stable times in a row.
+	// numOk is the number of consecutive calls of f() that have returned the
+	// correct answer.
+	numOk := 0
+	backoff := opts.Backoff
+	// slept is the total time slept, and sleptBeforeOk the time slept until
+	// the current streak of correct answers.
+	var slept, sleptBeforeOk time.Duration
+	// history records the outputs of f, to explain a failure.
+	var history retryHistory
+	clock := td.clock()
+	start := clock.Now()
+	for i := 0; ; i++ {
+		s := f()
+		history.add(s, clock.Now().Sub(start))
+		if opts.Compare(td.Expected, s) {
+			if numOk == 0 {
+				sleptBeforeOk = slept
+			}
+			numOk++
+		} else {
+			numOk = 0
+		}
+		if numOk == opts.Stable || i == opts.MaxAttempts || slept >= opts.Timeout {
+			// Don't count the calls which confirmed the expected output.
+			retries, retried := i, slept
+			if numOk == opts.Stable {
+				retries, retried = i-opts.Stable+1, sleptBeforeOk
+			}
+			if retries > 0 {
+				td.Logf(tb, "retried for %s (%d times)", retried, retries)
+			}
+			if numOk == 0 {
+				td.Logf(tb, "%s", history)
+			}
+			return s
+		}
+		if err := clock.Sleep(ctx, backoff); err != nil {
+			td.Logf(tb, "stopped retrying after %d calls: %v", i+1, err)
+			if numOk == 0 {
+				td.Logf(tb, "%s", history)
+			}
+			return s
+		}
+		slept += backoff
+		backoff = time.Duration(float64(backoff) * opts.BackoffMultiplier)
+		if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+func (opts *RetryOptions) setDefaults() {
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 100
+	}
+	if opts.Stable == 0 {
+		opts.Stable = 3
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = opts.Timeout/time.Duration(opts.MaxAttempts) + 1
+	}
+	if opts.BackoffMultiplier == 0 {
+		opts.BackoffMultiplier = 1
+	}
+	if opts.Compare == nil {
+		opts.Compare = func(expected, actual string) bool {
+			return strings.TrimSpace(actual) == strings.TrimSpace(expected)
+		}
+	}
+}
+
+// stripRetryArg removes the retry argument from the directive, if present. It
+// returns the timeout, which defaults to that of Retry, and whether the
+// argument was present. Once enabled with RetryArg, the argument can be used
+// with any directive:
+//
+//	cmd retry
+//	cmd retry=5s
+//
+// for the handler to be called repeatedly, as with RetryFor, until its output
+// matches the expected results. The argument is not seen by the handler, and
+// it is kept when the test file is rewritten.
+func stripRetryArg(t testing.TB, d *TestData) (time.Duration, bool) {
+	t.Helper()
+	arg, ok := stripArg(d, retryArg)
+	if !ok {
+		return 0, false
+	}
+	timeout := time.Second
+	switch len(arg.Vals) {
+	case 0:
+	case 1:
+		var err error
+		if timeout, err = time.ParseDuration(arg.Vals[0]); err != nil {
+			d.Fatalf(t, "invalid %s argument: %v", retryArg, err)
+		}
+	default:
+		d.Fatalf(t, "invalid %s argument: expected a single duration", retryArg)
+	}
+	return timeout, true
+}
+
+// retryArg is the argument handled by stripRetryArg.
+const retryArg = "retry"
+
+// clock returns the Clock used by Retry.
+func (td *TestData) clock() Clock {
+	if td.Clock == nil {
+		return realClock{}
+	}
+	return td.Clock
+}
+
+// retryHistory is the sequence of the outputs produced while retrying, in
+// which repeated outputs are recorded once.
+type retryHistory []retryOutput
+
+type retryOutput struct {
+	output string
+	// at is the time of the first call which produced the output, relative to
+	// the first call.
+	at time.Duration
+	// count is the number of consecutive calls which produced the output.
+	count int
+}
+
+func (h *retryHistory) add(output string, at time.Duration) {
+	if n := len(*h); n > 0 && (*h)[n-1].output == output {
+		(*h)[n-1].count++
+		return
+	}
+	*h = append(*h, retryOutput{output: output, at: at, count: 1})
+}
+
+// String formats the history, for example:
+//
+//	retry history (3 outputs):
+//	+0s (1 time):
+//	  starting
+//	+10.2ms (4 times):
+//	  running
+//	+51.3ms (95 times):
+//	  stalled
+func (h retryHistory) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "retry history (%d outputs):", len(h))
+	for _, o := range h {
+		times := "times"
+		if o.count == 1 {
+			times = "time"
+		}
+		fmt.Fprintf(&buf, "\n+%s (%d %s):", o.at.Round(100*time.Microsecond), o.count, times)
+		output := strings.TrimSuffix(o.output, "\n")
+		if output == "" {
+			output = "<empty>"
+		}
+		for _, line := range strings.Split(output, "\n") {
+			fmt.Fprintf(&buf, "\n  %s", line)
+		}
+	}
+	return buf.String()
+}
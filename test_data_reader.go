@@ -26,8 +26,30 @@ type testDataReader struct {
 	sourceName string
 	reader     io.Reader
 	scanner    *lineScanner
+	items      *itemReader
 	data       TestData
-	rewrite    *bytes.Buffer
+	rewrite    *rewriteBuffer
+
+	// newHandler, if set, is used to construct the handler for each
+	// subtest that is declared as parallel. If it is not set, parallel
+	// subtests are run sequentially.
+	newHandler func(t testing.TB) func(testing.TB, *TestData) string
+	// opts are the options of the test file; nil selects the defaults.
+	opts *runOptions
+	// accept is set in -datadriven-accept mode, in which only the accepted
+	// mismatches are rewritten.
+	accept bool
+	// rawExpected is the raw text of the expected results of the current
+	// directive, which are kept as they were unless a mismatch is accepted.
+	rawExpected string
+	// rejected collects the mismatches rejected in -datadriven-accept mode.
+	// It is shared with the subtest readers, so that they are reported on
+	// the file's test once the file has been rewritten.
+	rejected *rejectedMismatches
+	// startedParallel is set once a parallel subtest has been started
+	// from this reader. The rewrite output is not complete until all
+	// these subtests have finished.
+	startedParallel bool
 }
 
 func newTestDataReader(
@@ -35,152 +57,169 @@ func newTestDataReader(
 ) *testDataReader {
 	t.Helper()
 
-	var rewrite *bytes.Buffer
+	var rewrite *rewriteBuffer
 	if record {
-		rewrite = &bytes.Buffer{}
+		rewrite = &rewriteBuffer{}
 	}
+	scanner := newLineScanner(file)
 	return &testDataReader{
 		sourceName: sourceName,
 		reader:     file,
-		scanner:    newLineScanner(file),
+		scanner:    scanner,
+		items:      newItemReader(scanner),
 		rewrite:    rewrite,
+		rejected:   &rejectedMismatches{},
 	}
 }
 
 func (r *testDataReader) Next(t testing.TB) bool {
 	t.Helper()
 
-	for r.scanner.Scan() {
-		// Ensure to not re-initialize r.data unless a line is read
+	for {
+		n, err := r.items.Next()
+		if n == nil {
+			if err != nil {
+				t.Fatalf("%s: %v", r.sourceName, err)
+			}
+			return false
+		}
+		// Ensure to not re-initialize r.data unless an item is read
 		// successfully. The reason is that we want to keep the last
 		// stored value of `Pos` after encountering EOF, to produce useful
 		// error messages.
 		r.data = TestData{}
-		line := r.scanner.Text()
-		r.emit(line)
-
-		// Update Pos early so that a late error message has an updated
-		// position.
-		pos := fmt.Sprintf("%s:%d", r.sourceName, r.scanner.line)
-		r.data.Pos = pos
-
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") {
-			// Skip comment lines.
-			continue
-		}
-
-		// Support wrapping directive lines using \, for example:
-		//   build-scalar \
-		//   vars(int)
-		for strings.HasSuffix(line, `\`) && r.scanner.Scan() {
-			nextLine := r.scanner.Text()
-			r.emit(nextLine)
-			line = strings.TrimSuffix(line, `\`) + " " + strings.TrimSpace(nextLine)
-		}
-
-		cmd, args, err := ParseLine(line)
-		if err != nil {
-			t.Fatalf("%s: %v", pos, err)
-		}
-		if cmd == "" {
-			// Nothing to do here.
-			continue
-		}
-
-		r.data.Cmd = cmd
-		r.data.CmdArgs = args
-
-		if cmd == "subtest" {
-			// Subtest directives do not have an input and expected output.
+		r.data.Pos = fmt.Sprintf("%s:%d", r.sourceName, n.Span().StartLine)
+
+		switch n := n.(type) {
+		case *Blank:
+			r.emitRaw(n.raw)
+		case *Comment:
+			r.emitRaw(n.raw)
+		case *Directive:
+			r.emitRaw(n.rawLine)
+			r.emitRaw(n.rawInput)
+			if perr, ok := err.(*ParseError); ok {
+				t.Fatalf("%s:%d: %s", r.sourceName, perr.Line, perr.Msg)
+			}
+			r.data.Cmd = n.Cmd
+			r.data.CmdArgs = n.CmdArgs
+			if n.Cmd == "subtest" {
+				return true
+			}
+			r.data.Input = n.Input
+			r.data.Expected = n.Expected
+			r.rawExpected = n.rawExpected
+			r.data.Rewrite = r.opts.rewriteResults(r.sourceName)
 			return true
 		}
+	}
+}
 
-		var buf bytes.Buffer
-		var separator bool
-		for r.scanner.Scan() {
-			line := r.scanner.Text()
-			if line == "----" {
-				separator = true
-				break
-			}
-
-			r.emit(line)
-			fmt.Fprintln(&buf, line)
-		}
+func (r *testDataReader) emit(s string) {
+	if r.rewrite != nil {
+		r.rewrite.WriteString(s)
+		r.rewrite.WriteString("\n")
+	}
+}
 
-		r.data.Input = strings.TrimSpace(buf.String())
+// rewriteOutput returns the rewritten test file.
+func (r *testDataReader) rewriteOutput() []byte {
+	data := r.rewrite.Bytes()
+	// Remove any trailing blank line.
+	if l := len(data); l > 2 && data[l-1] == '\n' && data[l-2] == '\n' {
+		data = data[:l-1]
+	}
+	return data
+}
 
-		if separator {
-			r.readExpected(t)
+// emitRaw emits the given lines of the test file, with their line
+// terminators normalized.
+func (r *testDataReader) emitRaw(raw string) {
+	if r.rewrite == nil || raw == "" {
+		return
+	}
+	for _, line := range strings.SplitAfter(raw, "\n") {
+		if line != "" {
+			r.emit(string(dropEOL([]byte(line))))
 		}
-
-		r.data.Rewrite = *rewriteTestFiles
-		return true
 	}
-	return false
 }
 
-func (r *testDataReader) readExpected(t testing.TB) {
-	var buf bytes.Buffer
-	var line string
-	var allowBlankLines bool
+// readSubTest consumes the remainder of a subtest whose opening directive has
+// just been read, up to and including the matching "subtest end". It returns
+// the raw text of the consumed lines, which can be run separately with
+// newSubTestReader. The second return value is false if EOF was encountered
+// first.
+//
+// Nothing is emitted into the rewrite output; the caller is responsible for
+// that.
+func (r *testDataReader) readSubTest(t testing.TB) (raw string, ok bool) {
+	t.Helper()
 
-	if r.scanner.Scan() {
-		line = r.scanner.Text()
-		if line == "----" {
-			allowBlankLines = true
+	var buf bytes.Buffer
+	r.scanner.record = &buf
+	rewrite := r.rewrite
+	r.rewrite = nil
+	defer func() {
+		r.scanner.record = nil
+		r.rewrite = rewrite
+	}()
+
+	for depth := 1; r.Next(t); {
+		if r.data.Cmd != "subtest" || len(r.data.CmdArgs) == 0 {
+			continue
+		}
+		if r.data.CmdArgs[0].Key != "end" {
+			depth++
+		} else if depth--; depth == 0 {
+			return buf.String(), true
 		}
 	}
+	return buf.String(), false
+}
 
-	if allowBlankLines {
-		// Look for two successive lines of "----" before terminating.
-		for r.scanner.Scan() {
-			line = r.scanner.Text()
-
-			if line == "----" {
-				if r.scanner.Scan() {
-					line2 := r.scanner.Text()
-					if line2 == "----" {
-						// Read the following blank line (if we don't do this, we will emit
-						// an extra blank line when rewriting).
-						if r.scanner.Scan() && r.scanner.Text() != "" {
-							t.Fatal("non-blank line after end of double ---- separator section")
-						}
-						break
-					}
-
-					fmt.Fprintln(&buf, line)
-					fmt.Fprintln(&buf, line2)
-					continue
-				}
-			}
-
-			fmt.Fprintln(&buf, line)
-		}
-	} else {
-		// Terminate on first blank line.
-		for {
-			if strings.TrimSpace(line) == "" {
-				break
-			}
+// newSubTestReader returns a reader for the raw text of a subtest previously
+// consumed by readSubTest.
+func (r *testDataReader) newSubTestReader(t testing.TB, startLine int, raw string) *testDataReader {
+	t.Helper()
 
-			fmt.Fprintln(&buf, line)
+	sr := newTestDataReader(t, r.sourceName, strings.NewReader(raw), r.rewrite != nil)
+	sr.scanner.line = startLine
+	sr.newHandler = r.newHandler
+	sr.opts = r.opts
+	sr.accept = r.accept
+	sr.rejected = r.rejected
+	return sr
+}
 
-			if !r.scanner.Scan() {
-				break
-			}
+// rewriteBuffer accumulates the rewritten contents of a test file. The output
+// of a parallel subtest is only known once the subtest completes, so a chunk
+// is reserved for it and the chunks are concatenated at the end.
+type rewriteBuffer struct {
+	chunks []*bytes.Buffer
+}
 
-			line = r.scanner.Text()
-		}
+// WriteString appends s to the last chunk.
+func (b *rewriteBuffer) WriteString(s string) {
+	if len(b.chunks) == 0 {
+		b.chunks = append(b.chunks, &bytes.Buffer{})
 	}
+	b.chunks[len(b.chunks)-1].WriteString(s)
+}
 
-	r.data.Expected = buf.String()
+// Reserve returns a new chunk that is positioned after everything written so
+// far. Subsequent writes go after the reserved chunk.
+func (b *rewriteBuffer) Reserve() *bytes.Buffer {
+	reserved := &bytes.Buffer{}
+	b.chunks = append(b.chunks, reserved, &bytes.Buffer{})
+	return reserved
 }
 
-func (r *testDataReader) emit(s string) {
-	if r.rewrite != nil {
-		r.rewrite.WriteString(s)
-		r.rewrite.WriteString("\n")
+// Bytes returns the concatenation of all the chunks.
+func (b *rewriteBuffer) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, c := range b.chunks {
+		buf.Write(c.Bytes())
 	}
+	return buf.Bytes()
 }
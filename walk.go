@@ -0,0 +1,601 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package datadriven
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Walk goes through all the files in a subdirectory, creating subtests to match
+// the file hierarchy; for each "leaf" file, the given function is called.
+//
+// This can be used in conjunction with RunTest. For example:
+//
+//	 datadriven.Walk(t, path, func (t *testing.T, path string) {
+//	   // initialize per-test state
+//	   datadriven.RunTest(t, path, func (t *testing.T, d *datadriven.TestData) string {
+//	    // ...
+//	   }
+//	 }
+//
+//	Files:
+//	  testdata/typing
+//	  testdata/logprops/scan
+//	  testdata/logprops/select
+//
+// If path is "testdata/typing", the function is called once and no subtests
+// are created.
+//
+// If path is "testdata/logprops", the function is called two times, in
+// separate subtests /scan, /select.
+//
+// If path is "testdata", the function is called three times, in subtest
+// hierarchy /typing, /logprops/scan, /logprops/select.
+//
+// The entries of each directory are visited in lexical order. Temporary and
+// hidden files are skipped, as well as the files and directories listed in
+// ignore files (see IgnoreFileName). The behavior of Walk can be further
+// customized using WalkOptions; for example, WalkParallel runs the files
+// concurrently and WalkExclude skips the files matching a pattern.
+func Walk(t *testing.T, path string, f func(t *testing.T, path string), opts ...WalkOption) {
+	t.Helper()
+	WalkAny(t, path, func(t testing.TB, path string) {
+		f(t.(*testing.T), path)
+	}, opts...)
+}
+
+// WalkAny is like Walk but works over a testing.TB.
+func WalkAny(
+	t testing.TB, path string, f func(t testing.TB, path string), opts ...WalkOption,
+) {
+	t.Helper()
+	walk(t, osFS{}, path, f, opts)
+}
+
+// WalkWithOptions is like Walk, but the function also receives the RunOptions
+// set with WalkRunOptions, to be passed to RunTestWithOptions (or
+// RunTestErrWithOptions). For example:
+//
+//	datadriven.WalkWithOptions(t, path, func(t *testing.T, path string, opts []datadriven.RunOption) {
+//	  datadriven.RunTestWithOptions(t, path, func(t *testing.T, d *datadriven.TestData) string {
+//	    // ...
+//	  }, opts...)
+//	}, datadriven.WalkRunOptions(datadriven.ComparisonArgs()))
+func WalkWithOptions(
+	t *testing.T,
+	path string,
+	f func(t *testing.T, path string, opts []RunOption),
+	opts ...WalkOption,
+) {
+	t.Helper()
+	WalkWithOptionsAny(t, path, func(t testing.TB, path string, opts []RunOption) {
+		f(t.(*testing.T), path, opts)
+	}, opts...)
+}
+
+// WalkWithOptionsAny is like WalkWithOptions but works over a testing.TB.
+func WalkWithOptionsAny(
+	t testing.TB,
+	path string,
+	f func(t testing.TB, path string, opts []RunOption),
+	opts ...WalkOption,
+) {
+	t.Helper()
+	var o walkOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	walk(t, osFS{}, path, func(t testing.TB, path string) {
+		f(t, path, o.runOptions)
+	}, opts)
+}
+
+// WalkOption is an option that can be passed to Walk, WalkAny and
+// WalkWithOptions.
+type WalkOption func(*walkOptions)
+
+type walkOptions struct {
+	// fs is the file system being walked.
+	fs walkFS
+
+	// parallel is set if each file is to be run in a parallel subtest.
+	parallel bool
+	// sem, if non-nil, bounds the number of files running concurrently when
+	// parallel is set.
+	sem chan struct{}
+
+	// include, exclude and extensions select the files to visit; see
+	// WalkInclude, WalkExclude and WalkExtensions.
+	include    []string
+	exclude    []string
+	extensions []string
+	// noFollowSymlinks is set if symbolic links are to be skipped.
+	noFollowSymlinks bool
+	// subTestName derives the name of a subtest from a file name. If nil,
+	// cutExt is used.
+	subTestName func(fileName string) string
+	// runOptions are passed to the function of WalkWithOptions; see
+	// WalkRunOptions.
+	runOptions []RunOption
+}
+
+// WalkParallel causes Walk to run each file (and each directory) in a parallel
+// subtest; see testing.T.Parallel. At most maxParallel files are run at the
+// same time; if maxParallel is zero or negative, the concurrency is only
+// bounded by the -test.parallel flag.
+//
+// Each file is rewritten independently when -rewrite is used, so the files
+// must not depend on each other. In verbose mode, the directives and
+// responses echoed for each file are collected and logged in one block when
+// the file completes, so that the output of concurrent files does not
+// interleave. The output of the parallel subtests of a file (see
+// RunTestParallel) is part of the block of the file. These subtests only run
+// once the function passed to Walk has returned, however, so they don't count
+// towards maxParallel: only -test.parallel bounds them.
+//
+// Parallel execution is only possible with a *testing.T; when WalkAny is
+// used with another testing.TB, the files are run sequentially.
+func WalkParallel(maxParallel int) WalkOption {
+	return func(o *walkOptions) {
+		o.parallel = true
+		o.sem = nil
+		if maxParallel > 0 {
+			o.sem = make(chan struct{}, maxParallel)
+		}
+	}
+}
+
+// WalkInclude restricts Walk to the files which match at least one of the
+// given glob patterns (see path.Match). Patterns are matched against both the
+// name of a file and its path relative to the directory being walked, using
+// forward slashes: "*.test" and "logprops/*" both match "logprops/scan.test".
+// Directories are always traversed.
+func WalkInclude(patterns ...string) WalkOption {
+	return func(o *walkOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// WalkExclude causes Walk to skip the files and directories which match any of
+// the given glob patterns. See WalkInclude for the syntax of the patterns.
+func WalkExclude(patterns ...string) WalkOption {
+	return func(o *walkOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// WalkExtensions restricts Walk to the files with one of the given
+// extensions, including the dot (e.g. ".test"). The empty string selects the
+// files without an extension.
+func WalkExtensions(exts ...string) WalkOption {
+	return func(o *walkOptions) {
+		o.extensions = append(o.extensions, exts...)
+	}
+}
+
+// WalkFollowSymlinks controls whether Walk follows symbolic links to files and
+// directories (the default), or skips them.
+func WalkFollowSymlinks(follow bool) WalkOption {
+	return func(o *walkOptions) {
+		o.noFollowSymlinks = !follow
+	}
+}
+
+// WalkSubTestName sets the function used to derive the name of the subtest for
+// a file or directory from its name. By default, the extension is removed from
+// the name.
+func WalkSubTestName(f func(fileName string) string) WalkOption {
+	return func(o *walkOptions) {
+		o.subTestName = f
+	}
+}
+
+// WalkRunOptions sets the RunOptions passed to the function of
+// WalkWithOptions, for it to run each file with them. They are ignored by
+// Walk.
+func WalkRunOptions(opts ...RunOption) WalkOption {
+	return func(o *walkOptions) {
+		o.runOptions = append(o.runOptions, opts...)
+	}
+}
+
+// IgnoreFileName is the name of the files that list the files and
+// directories to be skipped by Walk. An ignore file contains glob patterns,
+// one per line, with the syntax described in WalkInclude; blank lines and
+// lines starting with # are ignored. The patterns apply to the directory
+// containing the ignore file and its subdirectories, and are matched relative
+// to that directory.
+const IgnoreFileName = ".datadrivenignore"
+
+func walk(
+	t testing.TB, fsys walkFS, path string, f func(t testing.TB, path string), opts []WalkOption,
+) {
+	t.Helper()
+
+	o := &walkOptions{fs: fsys}
+	for _, opt := range opts {
+		opt(o)
+	}
+	finfo, err := fsys.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finfo.IsDir() {
+		// A file that is passed explicitly is run regardless of the filters.
+		walkFile(t, path, f, o)
+		return
+	}
+	if err := o.validatePatterns(); err != nil {
+		t.Fatal(err)
+	}
+	walkDir(t, path, "" /* relDir */, nil /* ignores */, f, o)
+}
+
+// WalkFiles calls fn for each of the files that Walk would visit under the
+// given path, in the same order, without running any tests. It is intended for
+// tools which operate on test files. If path is a file, fn is only called for
+// it. WalkFiles stops at the first error returned by fn, and returns it.
+//
+// The WalkParallel and WalkSubTestName options have no effect.
+func WalkFiles(path string, fn func(path string) error, opts ...WalkOption) error {
+	o := &walkOptions{fs: osFS{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !finfo.IsDir() {
+		return fn(path)
+	}
+	if err := o.validatePatterns(); err != nil {
+		return err
+	}
+	return walkFiles(path, "" /* relDir */, nil /* ignores */, fn, o)
+}
+
+// walkFiles calls fn for the files visited in dir, recursively, like walkDir.
+func walkFiles(
+	dir, relDir string, ignores []ignoreRules, fn func(path string) error, o *walkOptions,
+) error {
+	entries, ignores, err := o.readDir(dir, relDir, ignores)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.isDir {
+			err = walkFiles(e.path, e.relPath, ignores, fn, o)
+		} else {
+			err = fn(e.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePatterns checks the syntax of the WalkInclude and WalkExclude
+// patterns, which would otherwise be silently ignored.
+func (o *walkOptions) validatePatterns() error {
+	for _, pattern := range append(o.include, o.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// walkFS is the file system traversed by a walk: either the OS file system,
+// with OS paths, or an fs.FS, with slash-separated paths.
+type walkFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	Join(elem ...string) string
+}
+
+// osFS is the walkFS for the OS file system.
+type osFS struct{}
+
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) Join(elem ...string) string                 { return filepath.Join(elem...) }
+
+// ioFS is the walkFS for an fs.FS.
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(f.fsys, name) }
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, name) }
+func (f ioFS) ReadFile(name string) ([]byte, error)       { return fs.ReadFile(f.fsys, name) }
+func (f ioFS) Join(elem ...string) string                 { return path.Join(elem...) }
+
+// walkDir creates a subtest for each entry of dir that is to be visited,
+// recursively. relDir is the slash-separated path of dir relative to the root
+// of the walk, and ignores are the rules from the ignore files in the parent
+// directories.
+func walkDir(
+	t testing.TB,
+	dir, relDir string,
+	ignores []ignoreRules,
+	f func(t testing.TB, path string),
+	o *walkOptions,
+) {
+	entries, ignores, err := o.readDir(dir, relDir, ignores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		e := e
+		subTest(t, e.subTestName, func(t testing.TB) {
+			if o.parallel && canRunParallel(t) {
+				t.(interface{ Parallel() }).Parallel()
+			}
+			if e.isDir {
+				walkDir(t, e.path, e.relPath, ignores, f, o)
+			} else {
+				walkFile(t, e.path, f, o)
+			}
+		})
+	}
+}
+
+// walkFile calls f for a file visited by the walk.
+func walkFile(t testing.TB, path string, f func(t testing.TB, path string), o *walkOptions) {
+	if o.parallel {
+		runParallelFile(t, path, f, o)
+		return
+	}
+	f(t, path)
+}
+
+// walkEntry is a file or directory to be visited by Walk.
+type walkEntry struct {
+	path        string
+	relPath     string
+	subTestName string
+	isDir       bool
+}
+
+// readDir returns the entries of dir which are to be visited, sorted by name,
+// along with the ignore rules that apply inside dir.
+func (o *walkOptions) readDir(
+	dir, relDir string, ignores []ignoreRules,
+) ([]walkEntry, []ignoreRules, error) {
+	dirEntries, err := o.fs.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rules, err := readIgnoreFile(o.fs, o.fs.Join(dir, IgnoreFileName), relDir); err != nil {
+		return nil, nil, err
+	} else if rules != nil {
+		// Don't modify the slice of the parent directory.
+		ignores = append(ignores[:len(ignores):len(ignores)], *rules)
+	}
+
+	var entries []walkEntry
+	for _, de := range dirEntries {
+		name := de.Name()
+		if tempFileRe.MatchString(name) {
+			// Temp or hidden file, don't even try processing.
+			continue
+		}
+		e := walkEntry{
+			path:    o.fs.Join(dir, name),
+			relPath: path.Join(relDir, name),
+			isDir:   de.IsDir(),
+		}
+		if de.Type()&fs.ModeSymlink != 0 {
+			if o.noFollowSymlinks {
+				continue
+			}
+			finfo, err := o.fs.Stat(e.path)
+			if err != nil {
+				return nil, nil, err
+			}
+			e.isDir = finfo.IsDir()
+		}
+		if o.excluded(e.relPath, ignores) || (!e.isDir && !o.selected(e.relPath)) {
+			continue
+		}
+		if o.subTestName != nil {
+			e.subTestName = o.subTestName(name)
+		} else {
+			e.subTestName = cutExt(name)
+		}
+		entries = append(entries, e)
+	}
+	return entries, ignores, nil
+}
+
+// excluded returns true if the file or directory at the given path (relative
+// to the root of the walk) is excluded by WalkExclude or by an ignore file.
+func (o *walkOptions) excluded(relPath string, ignores []ignoreRules) bool {
+	if matchAny(o.exclude, relPath) {
+		return true
+	}
+	for _, rules := range ignores {
+		if rules.dir == "" {
+			if matchAny(rules.patterns, relPath) {
+				return true
+			}
+		} else if strings.HasPrefix(relPath, rules.dir+"/") &&
+			matchAny(rules.patterns, strings.TrimPrefix(relPath, rules.dir+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected returns true if the file at the given path (relative to the root
+// of the walk) passes the WalkInclude and WalkExtensions filters.
+func (o *walkOptions) selected(relPath string) bool {
+	if len(o.include) > 0 && !matchAny(o.include, relPath) {
+		return false
+	}
+	if len(o.extensions) > 0 {
+		ext := path.Ext(relPath)
+		for _, e := range o.extensions {
+			if e == ext {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchAny returns true if any of the patterns matches either the given
+// slash-separated path or its last element.
+func matchAny(patterns []string, relPath string) bool {
+	base := path.Base(relPath)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, relPath); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRules are the patterns read from an ignore file.
+type ignoreRules struct {
+	// dir is the slash-separated path of the directory containing the ignore
+	// file, relative to the root of the walk.
+	dir      string
+	patterns []string
+}
+
+// readIgnoreFile reads the ignore file at the given path, if it exists. It
+// returns nil if there is no such file.
+func readIgnoreFile(fsys walkFS, filename string, relDir string) (*ignoreRules, error) {
+	data, err := fsys.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rules := &ignoreRules{dir: relDir}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %v", filename, i+1, line, err)
+		}
+		rules.patterns = append(rules.patterns, line)
+	}
+	return rules, nil
+}
+
+// runParallelFile calls f for a file visited by a parallel walk. The subtest
+// has already been marked parallel by the caller; here we wait for our turn
+// and arrange for the verbose output to be logged in one block.
+//
+// The parallel subtests of the file (see RunTestParallel) only run once f has
+// returned, so the output is logged in a cleanup, which runs once they have
+// completed too. The turn ends when f returns, however: a file waiting for its
+// turn occupies one of the -test.parallel slots, which the parallel subtests
+// also need, so holding the turn until then could deadlock.
+func runParallelFile(t testing.TB, path string, f func(t testing.TB, path string), o *walkOptions) {
+	buf := &syncBuffer{}
+	name := t.Name()
+	parallelLogs.Store(name, buf)
+	t.Cleanup(func() {
+		parallelLogs.Delete(name)
+		if s := strings.TrimRight(buf.String(), "\n"); s != "" {
+			t.Log(s)
+		}
+	})
+	if o.sem != nil {
+		o.sem <- struct{}{}
+		defer func() { <-o.sem }()
+	}
+	f(t, path)
+}
+
+// parallelLogs maps the names of the per-file subtests of a parallel walk to
+// the *syncBuffer which accumulates the verbose output for that file.
+var parallelLogs sync.Map
+
+// logVerbose echoes a directive and its response in verbose mode. The output
+// is normally logged right away, but it is buffered if t is running (a
+// subtest of) a file in a parallel walk.
+func logVerbose(t testing.TB, format string, args ...interface{}) {
+	t.Helper()
+	for name := t.Name(); ; {
+		if buf, ok := parallelLogs.Load(name); ok {
+			buf.(*syncBuffer).Printf(format, args...)
+			return
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	t.Logf(format, args...)
+}
+
+// syncBuffer is a bytes.Buffer that can be written concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Printf appends the formatted string to the buffer, terminating it with a
+// newline if necessary.
+func (b *syncBuffer) Printf(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := fmt.Sprintf(format, args...)
+	b.buf.WriteString(s)
+	if !strings.HasSuffix(s, "\n") {
+		b.buf.WriteByte('\n')
+	}
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// cutExt returns the given file name with the extension removed, if there is
+// one.
+func cutExt(fileName string) string {
+	extStart := len(fileName) - len(filepath.Ext(fileName))
+	return fileName[:extStart]
+}
+
+// Ignore files named .XXXX, XXX~ or #XXX#.
+var tempFileRe = regexp.MustCompile(`(^\..*)|(.*~$)|(^#.*#$)`)